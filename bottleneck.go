@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// AnalysisWindow is how far back, in simulated time, utilisation and
+	// queue growth are measured, so that a run in progress is judged on how
+	// it is doing now.
+	AnalysisWindow = 5 * time.Second
+	// SaturatedUtilisation is the utilisation, in percent, at which a node
+	// has no capacity left to spare.
+	SaturatedUtilisation = 90
+	// QueueGrowthThreshold is the growth, in requests per second, at which
+	// a queue is falling behind rather than absorbing bursts.
+	QueueGrowthThreshold = 1
+)
+
+// Analysis ranks the nodes of a run by how much they hold it back, most
+// first. Nodes of a type that are fed by the same nodes, such as the
+// servers behind a load balancer, are judged together as a pool. Times are
+// in simulated milliseconds.
+type Analysis struct {
+	RunID       string       `json:"runId"`
+	State       string       `json:"state"`
+	Elapsed     int          `json:"elapsed"`
+	Latency     int          `json:"latency"`
+	Explanation string       `json:"explanation"`
+	Bottlenecks []Bottleneck `json:"bottlenecks"`
+}
+
+// Bottleneck describes how loaded a node or pool is. Utilisation and
+// QueueGrowth are measured over the last AnalysisWindow of the run, and
+// Contribution is the share of the clients' latency the pool adds along the
+// critical path. Score weighs the three, from 0 to 1.
+type Bottleneck struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	Nodes          []string `json:"nodes"`
+	Score          float64  `json:"score"`
+	Saturated      bool     `json:"saturated"`
+	Utilisation    *float64 `json:"utilisation,omitempty"`
+	Queued         int      `json:"queued"`
+	QueueGrowth    float64  `json:"queueGrowth"`
+	Wait           int      `json:"wait"`
+	Service        int      `json:"service"`
+	Contribution   float64  `json:"contribution"`
+	OnCriticalPath bool     `json:"onCriticalPath"`
+	Explanation    string   `json:"explanation"`
+}
+
+// nodePool is a group of nodes analysed together.
+type nodePool struct {
+	nodeType string
+	nodes    []string
+	children []*nodePool
+
+	handled     int
+	wait        float64
+	service     float64
+	utilisation *float64
+	queued      int
+	growth      float64
+	exclusive   float64
+	onPath      bool
+}
+
+// Analyze finds the bottlenecks of a run, by default the one in progress or
+// else the latest. Only runs still in memory can be analysed.
+func (s *System) Analyze(runID string) (Analysis, error) {
+	s.mutex.RLock()
+	run := s.run
+	if runID != "" || run == nil {
+		run = nil
+		for i := len(s.runs) - 1; i >= 0; i-- {
+			if runID == "" || s.runs[i].ID == runID {
+				run = s.runs[i]
+				break
+			}
+		}
+	}
+	if run == nil {
+		s.mutex.RUnlock()
+		if runID == "" {
+			return Analysis{}, fmt.Errorf("%w: system %s has not run yet", ErrRunNotFound, s.ID)
+		}
+		return Analysis{}, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+
+	var summary RunSummary
+	record := run.Record()
+	if run == s.run {
+		summary = s.summarize()
+	} else if record.Summary != nil {
+		summary = *record.Summary
+	}
+	s.mutex.RUnlock()
+
+	return analyze(run, record, summary), nil
+}
+
+func analyze(run *Run, record RunRecord, summary RunSummary) Analysis {
+	analysis := Analysis{
+		RunID:       record.ID,
+		State:       record.State,
+		Elapsed:     summary.Duration,
+		Latency:     summary.Latency.Mean,
+		Bottlenecks: []Bottleneck{},
+	}
+
+	nodes := map[string]NodeSummary{}
+	for _, node := range summary.Nodes {
+		nodes[node.NodeID] = node
+	}
+	pools, clients := poolNodes(record.Design)
+	for _, pool := range pools {
+		pool.measure(run, nodes)
+	}
+	markCriticalPath(clients)
+
+	for _, pool := range pools {
+		analysis.Bottlenecks = append(analysis.Bottlenecks, pool.bottleneck(analysis.Latency))
+	}
+	sort.SliceStable(analysis.Bottlenecks, func(i, j int) bool {
+		return analysis.Bottlenecks[i].Score > analysis.Bottlenecks[j].Score
+	})
+
+	switch {
+	case len(analysis.Bottlenecks) == 0:
+		analysis.Explanation = "nothing to analyse: the design has no nodes besides its clients"
+	case analysis.Bottlenecks[0].Saturated:
+		top := analysis.Bottlenecks[0]
+		analysis.Explanation = fmt.Sprintf("%s is the bottleneck: %s", top.Name, top.Explanation)
+	default:
+		top := analysis.Bottlenecks[0]
+		analysis.Explanation = fmt.Sprintf("no node is saturated; the most loaded is %s: %s", top.Name, top.Explanation)
+	}
+	return analysis
+}
+
+// poolNodes groups the nodes of a design, other than its clients, by type
+// and by the nodes that feed them, and links the groups along the edges. It
+// also returns a pool for each client, to start the critical path from.
+func poolNodes(design SystemRecord) ([]*nodePool, []*nodePool) {
+	sources := map[string][]string{}
+	targets := map[string][]string{}
+	for _, edge := range design.Edges {
+		sources[edge.Target] = append(sources[edge.Target], edge.Source)
+		targets[edge.Source] = append(targets[edge.Source], edge.Target)
+	}
+
+	pools := []*nodePool{}
+	clients := []*nodePool{}
+	byKey := map[string]*nodePool{}
+	poolOf := map[string]*nodePool{}
+	for _, node := range design.Nodes {
+		if node.Type == ClientType {
+			client := &nodePool{nodeType: node.Type, nodes: []string{node.ID}}
+			clients = append(clients, client)
+			poolOf[node.ID] = client
+			continue
+		}
+
+		fedBy := append([]string{}, sources[node.ID]...)
+		sort.Strings(fedBy)
+		key := node.Type + "/" + strings.Join(fedBy, ",")
+		pool, ok := byKey[key]
+		if !ok {
+			pool = &nodePool{nodeType: node.Type}
+			byKey[key] = pool
+			pools = append(pools, pool)
+		}
+		pool.nodes = append(pool.nodes, node.ID)
+		poolOf[node.ID] = pool
+	}
+
+	for _, pool := range append(append([]*nodePool{}, clients...), pools...) {
+		linked := map[*nodePool]bool{}
+		for _, nodeID := range pool.nodes {
+			for _, target := range targets[nodeID] {
+				child := poolOf[target]
+				if child != nil && !linked[child] {
+					linked[child] = true
+					pool.children = append(pool.children, child)
+				}
+			}
+		}
+	}
+	return pools, clients
+}
+
+// measure averages the pool's nodes, weighting times by the requests each
+// handled, and sums their queues.
+func (p *nodePool) measure(run *Run, nodes map[string]NodeSummary) {
+	var waits, services float64
+	var utilisation float64
+	measured := 0
+	for _, nodeID := range p.nodes {
+		node, ok := nodes[nodeID]
+		if !ok {
+			continue
+		}
+
+		p.handled += node.Handled
+		if node.Wait != nil {
+			waits += float64(node.Wait.Mean * node.Handled)
+		}
+		if node.Latency != nil {
+			services += float64(node.Latency.Mean * node.Handled)
+		}
+		if node.Load != nil {
+			recent, ok := run.recentMean(nodeID, NewUtilisation(0).Name)
+			if !ok {
+				recent = node.Load.Utilisation
+			}
+			utilisation += recent
+			measured++
+		}
+
+		queued, growth := run.queueTrend(nodeID)
+		p.queued += queued
+		p.growth += growth
+	}
+
+	if p.handled > 0 {
+		p.wait = waits / float64(p.handled)
+		p.service = services / float64(p.handled)
+	}
+	if measured > 0 {
+		mean := round2(utilisation / float64(measured))
+		p.utilisation = &mean
+	}
+}
+
+// holdsRequests tells whether the pool's nodes keep a request while they
+// call the nodes after them, so that their service time includes those
+// calls. Load balancers and queues pass requests straight on.
+func (p *nodePool) holdsRequests() bool {
+	return p.nodeType != LoadBalancerType && p.nodeType != QueueType
+}
+
+// markCriticalPath follows the slowest pool from every client, working out
+// how much time each pool along the way adds of its own.
+func markCriticalPath(clients []*nodePool) {
+	for _, client := range clients {
+		visited := map[*nodePool]bool{}
+		pool := client
+		for {
+			var slowest *nodePool
+			for _, child := range pool.children {
+				if !visited[child] && (slowest == nil || child.wait+child.service > slowest.wait+slowest.service) {
+					slowest = child
+				}
+			}
+			if slowest == nil {
+				break
+			}
+			visited[slowest] = true
+			slowest.onPath = true
+			pool = slowest
+		}
+	}
+}
+
+// ownTime is the time a request spends in the pool, less the time the pool
+// spends waiting on the pools it calls, in proportion to how often it calls
+// them.
+func (p *nodePool) ownTime() float64 {
+	own := p.wait + p.service
+	if !p.holdsRequests() || p.handled == 0 {
+		return own
+	}
+
+	for _, child := range p.children {
+		if child.onPath {
+			calls := math.Min(1, float64(child.handled)/float64(p.handled))
+			own -= (child.wait + child.service) * calls
+		}
+	}
+	return math.Max(p.wait, own)
+}
+
+func (p *nodePool) bottleneck(latency int) Bottleneck {
+	bottleneck := Bottleneck{
+		Name:           p.name(),
+		Type:           p.nodeType,
+		Nodes:          p.nodes,
+		Utilisation:    p.utilisation,
+		Queued:         p.queued,
+		QueueGrowth:    round2(p.growth),
+		Wait:           int(math.Round(p.wait)),
+		Service:        int(math.Round(p.service)),
+		OnCriticalPath: p.onPath,
+	}
+	if p.onPath && latency > 0 {
+		bottleneck.Contribution = round2(math.Min(100, p.ownTime()/float64(latency)*100))
+	}
+
+	var utilisationScore float64
+	if p.utilisation != nil {
+		utilisationScore = clamp((*p.utilisation - 60) / 40)
+		bottleneck.Saturated = *p.utilisation >= SaturatedUtilisation
+	}
+	queueScore := clamp(p.growth / (10 * QueueGrowthThreshold))
+	if p.growth >= QueueGrowthThreshold {
+		bottleneck.Saturated = true
+	}
+	bottleneck.Score = round2(0.4*utilisationScore + 0.3*queueScore + 0.3*bottleneck.Contribution/100)
+
+	bottleneck.Explanation = p.explain(bottleneck)
+	return bottleneck
+}
+
+func (p *nodePool) name() string {
+	if len(p.nodes) == 1 {
+		return p.nodeType
+	}
+	return fmt.Sprintf("%s pool of %d", p.nodeType, len(p.nodes))
+}
+
+// explain puts the signals that stand out into words, for example "at 98%
+// utilisation; queue growing 40 req/s".
+func (p *nodePool) explain(bottleneck Bottleneck) string {
+	parts := []string{}
+	if p.utilisation != nil {
+		parts = append(parts, fmt.Sprintf("at %.0f%% utilisation", *p.utilisation))
+	}
+	switch {
+	case p.growth >= QueueGrowthThreshold:
+		parts = append(parts, fmt.Sprintf("queue growing %.0f req/s", p.growth))
+	case p.queued > 0:
+		parts = append(parts, fmt.Sprintf("%d queued but holding steady", p.queued))
+	}
+	if bottleneck.Wait > 0 {
+		parts = append(parts, fmt.Sprintf("requests wait %dms before service", bottleneck.Wait))
+	}
+	if bottleneck.Contribution > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%% of end-to-end latency", bottleneck.Contribution))
+	}
+	if len(parts) == 0 {
+		return "idle"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// recentMean averages a node metric over the last AnalysisWindow of the run.
+func (r *Run) recentMean(nodeID string, metric string) (float64, bool) {
+	points := r.recent(nodeID, metric)
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	var sum float64
+	for _, point := range points {
+		sum += point.Value
+	}
+	return sum / float64(len(points)), true
+}
+
+// queueTrend returns the latest length of a node's queue and how fast it
+// grew over the last AnalysisWindow of the run, in requests per second, from
+// a least squares fit.
+func (r *Run) queueTrend(nodeID string) (int, float64) {
+	points := r.recent(nodeID, NewQueued(0).Name)
+	if len(points) == 0 {
+		return 0, 0
+	}
+	latest := int(points[len(points)-1].Value)
+	if len(points) < 2 {
+		return latest, 0
+	}
+
+	var meanTime, meanValue float64
+	for _, point := range points {
+		meanTime += float64(point.Time)
+		meanValue += point.Value
+	}
+	meanTime /= float64(len(points))
+	meanValue /= float64(len(points))
+
+	var covariance, variance float64
+	for _, point := range points {
+		covariance += (float64(point.Time) - meanTime) * (point.Value - meanValue)
+		variance += (float64(point.Time) - meanTime) * (float64(point.Time) - meanTime)
+	}
+	if variance == 0 {
+		return latest, 0
+	}
+	return latest, covariance / variance * 1000
+}
+
+// recent returns a copy of the points of a node metric over the last
+// AnalysisWindow of its series.
+func (r *Run) recent(nodeID string, metric string) []Point {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	series, ok := r.series[nodeID+"/"+metric]
+	if !ok || len(series.Points) == 0 {
+		return nil
+	}
+
+	from := series.Points[len(series.Points)-1].Time - int(AnalysisWindow.Milliseconds())
+	start := sort.Search(len(series.Points), func(i int) bool {
+		return series.Points[i].Time >= from
+	})
+	return append([]Point{}, series.Points[start:]...)
+}
+
+func clamp(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeFindsBottleneck(t *testing.T) {
+	tests := []struct {
+		name        string
+		design      string
+		saturated   bool
+		explanation string
+	}{
+		{
+			"slow servers",
+			"workload {numRequests: 150, requestInterval: 20}\nseed 1\nclient -> lb -> [server x2 {maxRoutines: 1, processingTimeLower: 200, processingTimeUpper: 200}] -> db {processingTimeLower: 5, processingTimeUpper: 5}",
+			true,
+			"server pool of 2 is the bottleneck",
+		},
+		{
+			"light load",
+			"workload {numRequests: 50, requestInterval: 100}\nseed 1\nclient -> lb -> [server x2 {maxRoutines: 10, processingTimeLower: 10, processingTimeUpper: 10}] -> db {processingTimeLower: 5, processingTimeUpper: 5}",
+			false,
+			"no node is saturated",
+		},
+	}
+	for _, test := range tests {
+		system, err := CompileDSL(test.design, Limits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runToEnd(t, system, MaxSpeed)
+
+		analysis, err := system.Analyze("")
+		system.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(analysis.Bottlenecks) != 3 {
+			t.Fatalf("%s: found %d pools, expected the load balancer, servers and database", test.name, len(analysis.Bottlenecks))
+		}
+		top := analysis.Bottlenecks[0]
+		if test.saturated && (top.Type != ServerType || len(top.Nodes) != 2 || !top.Saturated) {
+			t.Errorf("%s: the top bottleneck is %+v", test.name, top)
+		}
+		for _, bottleneck := range analysis.Bottlenecks {
+			if !test.saturated && bottleneck.Saturated {
+				t.Errorf("%s: %s is saturated", test.name, bottleneck.Name)
+			}
+		}
+		if !strings.HasPrefix(analysis.Explanation, test.explanation) {
+			t.Errorf("%s: explained %q, expected it to start with %q", test.name, analysis.Explanation, test.explanation)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
-	"github.com/lithammer/shortuuid/v3"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,6 +15,29 @@ const (
 	RequestInterval = 10 * time.Millisecond
 )
 
+// Workload describes the requests every client sends during a run.
+type Workload struct {
+	NumRequests     int `json:"numRequests" yaml:"numRequests"`
+	RequestInterval int `json:"requestInterval" yaml:"requestInterval"`
+}
+
+func DefaultWorkload() Workload {
+	return Workload{
+		NumRequests:     NumRequests,
+		RequestInterval: int(RequestInterval.Milliseconds()),
+	}
+}
+
+func (w Workload) Validate() error {
+	if w.NumRequests < 1 {
+		return errors.New("workload numRequests must be at least 1")
+	}
+	if w.RequestInterval < 0 {
+		return errors.New("workload requestInterval must not be negative")
+	}
+	return nil
+}
+
 type Client struct {
 	ID       string
 	Type     string
@@ -21,16 +46,26 @@ type Client struct {
 	outRequests  chan Request
 	outResponses chan Response
 
+	workload     Workload
 	requestStore *RequestStore
-	messages     chan Message
+	hub          *Hub
+	clock        *Clock
+	numResponses int64
+	numFailed    int64
+	totalLatency int64
+	latencies    *Histogram
+	tracer       *Tracer
 }
 
-func NewClient(messages chan Message) *Client {
+func NewClient(id string, hub *Hub, clock *Clock) *Client {
 	return &Client{
-		ID:           shortuuid.New(),
-		messages:     messages,
+		ID:           id,
+		hub:          hub,
+		clock:        clock,
 		Type:         ClientType,
+		workload:     DefaultWorkload(),
 		requestStore: NewRequestStore(),
+		latencies:    NewHistogram(),
 	}
 }
 
@@ -50,36 +85,62 @@ func (c *Client) SetPosition(pos Position) {
 	c.Position = pos
 }
 
+func (c *Client) GetConfig() NodeConfig {
+	return NodeConfig{}
+}
+
+func (c *Client) SetConfig(config NodeConfig) error {
+	if config != (NodeConfig{}) {
+		return fmt.Errorf("%s has no configuration", c.Type)
+	}
+	return nil
+}
+
 func (c *Client) SetOutChannels(outRequests chan Request, outResponses chan Response) {
 	c.outRequests = outRequests
 	c.outResponses = outResponses
 }
 
 func (c *Client) Run(ctx context.Context, wg *sync.WaitGroup, cancel context.CancelFunc) {
-	var startTime time.Time
+	startTime := c.clock.Now()
 
 	wg.Add(1)
+	c.clock.Hold()
 	go func() {
 		defer wg.Done()
 
-		startTime = time.Now()
-		for i := 0; i < NumRequests; i++ {
+		for i := 0; i < c.workload.NumRequests; i++ {
 			log.Printf("%s sending request number %d", c.Type, i)
+			err := c.clock.Acquire(ctx)
+			if err != nil {
+				log.Printf("%s request goroutine cancelled", c.Type)
+				return
+			}
+
+			now := c.clock.Now()
+			newRequest := Request{ID: i, Origin: c.ID, SentAt: now, EnqueuedAt: now}
+			newRequest = c.tracer.Begin(c, newRequest, newRequest.SentAt)
+			c.requestStore.Put(i, newRequest)
+			c.clock.Hold()
 			select {
+			case c.outRequests <- newRequest:
 			case <-ctx.Done():
 				log.Printf("%s request goroutine cancelled", c.Type)
 				return
-			default:
-				newRequest := Request{ID: i, SentAt: time.Now()}
-				c.outRequests <- newRequest
-				c.requestStore.Put(i, newRequest)
-				time.Sleep(RequestInterval)
+			}
+			c.clock.Record(NewEvent(c, RequestSentEvent, i, fmt.Sprintf("%s sent request %d", c.Type, i)))
+
+			err = c.clock.Sleep(ctx, time.Duration(c.workload.RequestInterval)*time.Millisecond)
+			if err != nil {
+				log.Printf("%s request goroutine cancelled", c.Type)
+				return
 			}
 		}
 		log.Printf("%s request goroutine complete", c.Type)
+		c.clock.Release()
 	}()
 
-	metricsTicker := time.NewTicker(100 * time.Millisecond)
+	metricsTicker := c.clock.NewTicker(ctx, MetricsInterval)
 
 	wg.Add(1)
 	go func() {
@@ -88,58 +149,105 @@ func (c *Client) Run(ctx context.Context, wg *sync.WaitGroup, cancel context.Can
 			wg.Done()
 		}()
 
-		totalLatency := 0
-		numResponses := 0
 		for {
 			select {
 			case <-ctx.Done():
 				log.Printf("%s response goroutine cancelled", c.Type)
 				return
 			case response := <-c.outResponses:
-				numResponses += 1
-				response.ReceivedAt = time.Now()
+				err := c.clock.Acquire(ctx)
+				if err != nil {
+					log.Printf("%s response goroutine cancelled", c.Type)
+					return
+				}
+
+				response.ReceivedAt = c.clock.Now()
 				latency := response.ReceivedAt.Sub(c.requestStore.Get(response.ID).SentAt)
-				totalLatency += int(latency.Milliseconds())
+				totalLatency := atomic.AddInt64(&c.totalLatency, latency.Milliseconds())
+				c.latencies.Record(latency.Milliseconds())
+				numResponses := atomic.AddInt64(&c.numResponses, 1)
+				if response.Failed {
+					atomic.AddInt64(&c.numFailed, 1)
+				}
+				c.tracer.End(c, response.TraceID, response.ReceivedAt, 0, response.Failed)
 
 				log.Printf("%s received response for request %d. Latency = %d, Avg. Latency = %d", c.Type, response.ID, latency.Milliseconds(), totalLatency/numResponses)
+				outcome := "response"
+				if response.Failed {
+					outcome = "error"
+				}
+				c.clock.Record(NewEvent(c, ResponseReceivedEvent, response.ID, fmt.Sprintf("%s received %s for request %d after %dms", c.Type, outcome, response.ID, latency.Milliseconds())))
+				c.clock.Release()
 
 			case _ = <-metricsTicker.C:
-				duration := int(time.Since(startTime).Seconds())
+				duration := int(c.clock.Now().Sub(startTime).Seconds())
+				numResponses := int(atomic.LoadInt64(&c.numResponses))
 				if duration == 0 || numResponses == 0 {
 					log.Printf("%s not sending metrics", c.Type)
+					c.clock.Release()
 					continue
 				}
 
-				log.Printf("%s sending metrics: Num Responses = %d, Avg. Latency = %d", c.Type, numResponses, totalLatency/numResponses)
-				c.messages <- Message{
-					NodeID: c.ID,
-					Metrics: []Metric{
-						NewNumResponses(numResponses),
-						NewAvgLatency(totalLatency / numResponses),
-					},
-				}
+				metrics := c.GetMetrics()
+				log.Printf("%s sending metrics: Num Responses = %d, Avg. Latency = %d", c.Type, metrics[0].Value, metrics[1].Value)
+				msg := NewMetricsMessage(c.ID, c.clock.Elapsed(), metrics)
+				c.hub.Publish(msg)
 
-				if numResponses == NumRequests {
+				// The clock is held until the run is cancelled, so that it
+				// ends at this tick
+				if numResponses == c.workload.NumRequests {
 					log.Printf("%s response goroutine complete", c.Type)
 					cancel()
+					c.clock.Release()
 					return
 				}
+				c.clock.Release()
 			}
 		}
 	}()
 }
 
-func (c *Client) Reset() {
+func (c *Client) Reset(run RunConfig) {
+	c.workload = run.Workload
 	c.requestStore = NewRequestStore()
+	atomic.StoreInt64(&c.numResponses, 0)
+	atomic.StoreInt64(&c.numFailed, 0)
+	atomic.StoreInt64(&c.totalLatency, 0)
+	c.latencies = NewHistogram()
+	c.tracer = run.Tracer
+}
+
+// Progress returns how many of the client's requests have been answered.
+func (c *Client) Progress() (int, int) {
+	return int(atomic.LoadInt64(&c.numResponses)), c.workload.NumRequests
 }
 
 func (c *Client) GetMetrics() []Metric {
+	numResponses := atomic.LoadInt64(&c.numResponses)
+	avgLatency := int64(0)
+	errorRate := int64(0)
+	if numResponses > 0 {
+		avgLatency = atomic.LoadInt64(&c.totalLatency) / numResponses
+		errorRate = atomic.LoadInt64(&c.numFailed) * 100 / numResponses
+	}
+
 	return []Metric{
-		NewNumResponses(0),
-		NewAvgLatency(0),
+		NewNumResponses(int(numResponses)),
+		NewAvgLatency(int(avgLatency)),
+		NewErrorRate(int(errorRate)),
 	}
 }
 
+// Latencies returns the response times the client measured in the current
+// run.
+func (c *Client) Latencies() *Histogram {
+	return c.latencies
+}
+
+func (c *Client) Summary(elapsed time.Duration) NodeSummary {
+	return NewNodeSummary(c, elapsed, atomic.LoadInt64(&c.numResponses), atomic.LoadInt64(&c.numFailed), c.latencies)
+}
+
 type RequestStore struct {
 	m     map[int]Request
 	mutex sync.RWMutex
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MinSpeed = 0.1
+	MaxSpeed = 100
+)
+
+// MaxClockLag is how far the clock may fall behind wall time, when events
+// take longer to handle than the speed allows, before it stops trying to
+// catch up.
+const MaxClockLag = 100 * time.Millisecond
+
+// Clock controls the passage of simulated time for a running system. Nodes
+// sleep, tick and timestamp through the clock so that the whole simulation can
+// be paused or sped up at once.
+//
+// Simulated time is virtual. It jumps from one deadline to the next, paced
+// against wall time by the speed, and only once the work the last deadline
+// set off has been done. Nodes Hold the clock for each goroutine they wake
+// and each message they send, and Release it once that is handled, so that
+// handling takes no simulated time however long it takes in wall time.
+type Clock struct {
+	mutex sync.Mutex
+
+	speed   float64
+	paused  bool
+	stopped bool
+	changed chan struct{}
+
+	steps     int
+	recording bool
+	events    chan Event
+
+	generation int
+	startedAt  time.Time
+	now        time.Duration
+	busy       int
+	timers     []*clockTimer
+
+	// Pacing measures wall time from anchorWall, when simulated time was at
+	// least anchorSim.
+	anchorWall time.Time
+	anchorSim  time.Duration
+}
+
+// clockTimer wakes a sleeper, or ticks a ticker, at its deadline.
+type clockTimer struct {
+	generation int
+	deadline   time.Duration
+	fired      bool
+	wake       chan struct{}
+
+	ticks  chan time.Time
+	period time.Duration
+}
+
+func NewClock() *Clock {
+	return &Clock{
+		speed:   1,
+		stopped: true,
+		changed: make(chan struct{}),
+		events:  make(chan Event, 1),
+	}
+}
+
+// Reset starts measuring a new run. The speed carries over between runs.
+func (c *Clock) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.paused = false
+	c.stopped = false
+	c.steps = 0
+	c.recording = false
+	c.startedAt = time.Now()
+	c.now = 0
+	c.busy = 0
+	c.timers = nil
+	c.anchorWall = c.startedAt
+	c.anchorSim = 0
+	c.notify()
+
+	go c.schedule(c.generation)
+}
+
+// Stop freezes the simulated time of the current run.
+func (c *Clock) Stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.stopped = true
+	c.notify()
+}
+
+func (c *Clock) Pause() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.steps = 0
+	if c.paused {
+		return
+	}
+	c.reanchor()
+	c.paused = true
+	c.notify()
+}
+
+func (c *Clock) Resume() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.steps = 0
+	if !c.paused {
+		return
+	}
+	c.reanchor()
+	c.paused = false
+	c.notify()
+}
+
+func (c *Clock) Speed() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.speed
+}
+
+// SetSpeed changes how fast simulated time passes relative to wall time.
+// Sleeps already in progress are rescaled.
+func (c *Clock) SetSpeed(speed float64) error {
+	if speed < MinSpeed || speed > MaxSpeed {
+		return fmt.Errorf("speed must be between %v and %v", MinSpeed, MaxSpeed)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reanchor()
+	c.speed = speed
+	c.notify()
+
+	return nil
+}
+
+// Elapsed returns the simulated time of the current run, excluding pauses.
+func (c *Clock) Elapsed() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// Now returns the simulated time, for timestamping requests and responses.
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.startedAt.Add(c.now)
+}
+
+// Hold keeps simulated time where it is until a matching Release. It is
+// called for a goroutine about to start and for a message about to be sent,
+// and the holder releases once it is blocked again or the message handled.
+func (c *Clock) Hold() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.busy++
+}
+
+// Release lets simulated time move on once nothing else holds it.
+func (c *Clock) Release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.release()
+}
+
+// Acquire blocks while the clock is paused and is called by nodes right
+// before an event. While stepping, the event that acquires the step pauses
+// the clock again so that exactly one event happens per step.
+func (c *Clock) Acquire(ctx context.Context) error {
+	for {
+		c.mutex.Lock()
+		if !c.paused {
+			if c.steps > 0 {
+				c.steps--
+				c.reanchor()
+				c.paused = true
+				c.notify()
+			}
+			c.mutex.Unlock()
+			return ctx.Err()
+		}
+		changed := c.changed
+		c.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Record reports an event that has just been applied. It is handed to a
+// pending Step and dropped otherwise.
+func (c *Clock) Record(event Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.recording {
+		return
+	}
+	c.recording = false
+
+	event.Time = int(c.now.Milliseconds())
+	select {
+	case c.events <- event:
+	default:
+	}
+}
+
+// Step lets a paused clock run until the next event and returns that event.
+// If nothing is ready to happen, time jumps straight to the next deadline.
+func (c *Clock) Step(ctx context.Context) (Event, error) {
+	c.mutex.Lock()
+	if !c.paused {
+		c.mutex.Unlock()
+		return Event{}, errors.New("clock must be paused to step")
+	}
+
+	select {
+	case <-c.events:
+	default:
+	}
+	c.steps = 1
+	c.recording = true
+	c.reanchor()
+	c.paused = false
+	c.notify()
+	c.mutex.Unlock()
+
+	select {
+	case event := <-c.events:
+		return event, nil
+	case <-ctx.Done():
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.recording = false
+		if c.steps > 0 {
+			c.steps = 0
+			c.reanchor()
+			c.paused = true
+			c.notify()
+		}
+		return Event{}, ctx.Err()
+	}
+}
+
+// Sleep blocks for d of simulated time. The caller must hold the clock,
+// which it gives up while asleep and holds again once woken. Pausing
+// mid-sleep holds the remainder until the clock is resumed.
+func (c *Clock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	c.mutex.Lock()
+	timer := c.add(c.now+d, 0, nil)
+	c.release()
+	c.mutex.Unlock()
+
+	select {
+	case <-timer.wake:
+		return nil
+	case <-ctx.Done():
+		c.cancel(timer)
+		return ctx.Err()
+	}
+}
+
+// Ticker delivers ticks every d of simulated time until ctx is cancelled or
+// the ticker is stopped. Each tick holds the clock until its receiver
+// releases it.
+type Ticker struct {
+	C     <-chan time.Time
+	clock *Clock
+	timer *clockTimer
+}
+
+func (c *Clock) NewTicker(ctx context.Context, d time.Duration) *Ticker {
+	ticks := make(chan time.Time, 1)
+
+	c.mutex.Lock()
+	ticker := &Ticker{
+		C:     ticks,
+		clock: c,
+		timer: c.add(c.now+d, d, ticks),
+	}
+	c.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		ticker.Stop()
+	}()
+
+	return ticker
+}
+
+// Stop ends the ticks, releasing the clock for a tick that was never
+// received.
+func (t *Ticker) Stop() {
+	t.clock.cancel(t.timer)
+}
+
+// schedule fires the timers of a run in order of deadline, each once the
+// clock is free and wall time has caught up with it.
+func (c *Clock) schedule(generation int) {
+	for {
+		c.mutex.Lock()
+		if c.generation != generation || c.stopped {
+			c.mutex.Unlock()
+			return
+		}
+		changed := c.changed
+		if c.paused || c.busy > 0 || len(c.timers) == 0 {
+			c.mutex.Unlock()
+			<-changed
+			continue
+		}
+
+		next := c.timers[0]
+		wait := time.Until(c.anchorWall.Add(time.Duration(float64(next.deadline-c.anchorSim) / c.speed)))
+		if wait > 0 && c.steps == 0 {
+			c.mutex.Unlock()
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-changed:
+				timer.Stop()
+			}
+			continue
+		}
+
+		if c.steps > 0 || -wait > MaxClockLag {
+			c.anchorWall = time.Now()
+			c.anchorSim = next.deadline
+		}
+		c.fire(next)
+		c.mutex.Unlock()
+	}
+}
+
+// fire moves simulated time to the timer's deadline and wakes its sleeper,
+// or ticks its ticker and sets the next tick. Callers must hold the mutex.
+func (c *Clock) fire(timer *clockTimer) {
+	c.timers = c.timers[1:]
+	c.now = timer.deadline
+
+	if timer.ticks == nil {
+		timer.fired = true
+		c.busy++
+		close(timer.wake)
+		return
+	}
+
+	c.insert(timer, timer.deadline+timer.period)
+	select {
+	case timer.ticks <- c.startedAt.Add(c.now):
+		c.busy++
+	default:
+	}
+}
+
+// add sets a timer for the deadline, ticking every period if it is a
+// ticker's. Callers must hold the mutex.
+func (c *Clock) add(deadline time.Duration, period time.Duration, ticks chan time.Time) *clockTimer {
+	timer := &clockTimer{
+		generation: c.generation,
+		wake:       make(chan struct{}),
+		ticks:      ticks,
+		period:     period,
+	}
+	c.insert(timer, deadline)
+	return timer
+}
+
+// insert queues the timer at the deadline, after any timer with the same
+// one. Ticks come after every sleeper due at the same time, so that metrics
+// see all that happened by then. Callers must hold the mutex.
+func (c *Clock) insert(timer *clockTimer, deadline time.Duration) {
+	timer.deadline = deadline
+
+	i := sort.Search(len(c.timers), func(i int) bool {
+		pending := c.timers[i]
+		if pending.deadline == deadline {
+			return timer.ticks == nil && pending.ticks != nil
+		}
+		return pending.deadline > deadline
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = timer
+}
+
+// cancel removes a timer whose sleeper or ticker gave up on it, releasing
+// the clock if it already fired. Timers of an earlier run are ignored.
+func (c *Clock) cancel(timer *clockTimer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if timer.generation != c.generation {
+		return
+	}
+	for i, pending := range c.timers {
+		if pending == timer {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+
+	if timer.ticks != nil {
+		select {
+		case <-timer.ticks:
+			c.release()
+		default:
+		}
+		return
+	}
+	if timer.fired {
+		timer.fired = false
+		c.release()
+	}
+}
+
+// release gives back a hold. Callers must hold the mutex.
+func (c *Clock) release() {
+	c.busy--
+	if c.busy == 0 {
+		c.notify()
+	}
+}
+
+// reanchor restarts pacing from now, keeping how far towards the next
+// deadline it had got. Callers must hold the mutex.
+func (c *Clock) reanchor() {
+	if !c.paused {
+		paced := c.anchorSim + time.Duration(float64(time.Since(c.anchorWall))*c.speed)
+		if len(c.timers) > 0 && paced > c.timers[0].deadline {
+			paced = c.timers[0].deadline
+		}
+		if paced < c.now {
+			paced = c.now
+		}
+		c.anchorSim = paced
+	}
+	c.anchorWall = time.Now()
+}
+
+// notify wakes everything blocked on the clock. Callers must hold the mutex.
+func (c *Clock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
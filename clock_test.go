@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// runToEnd starts the system at the given speed and waits for the run to
+// complete, returning its summary.
+func runToEnd(t *testing.T, system *System, speed float64) RunSummary {
+	t.Helper()
+
+	err := system.SetSpeed(speed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = system.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for system.Status().State == RunningState {
+		if time.Now().After(deadline) {
+			t.Fatalf("run at %vx did not complete", speed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := system.Status()
+	if status.State != CompletedState {
+		t.Fatalf("run at %vx ended %s: %s", speed, status.State, status.Error)
+	}
+	run, err := system.FindRun(status.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *run.Record().Summary
+}
+
+func TestSimulatedDurationDoesNotDependOnSpeed(t *testing.T) {
+	summaries := map[float64]RunSummary{}
+	for _, speed := range []float64{1, 10, MaxSpeed} {
+		system, err := NewSystemFromTemplate("three-tier")
+		if err != nil {
+			t.Fatal(err)
+		}
+		system.SetSeed(42)
+		err = system.SetWorkload(Workload{NumRequests: 150, RequestInterval: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		summaries[speed] = runToEnd(t, system, speed)
+		system.Close()
+	}
+
+	base := summaries[1]
+	if base.Duration < 1500 || base.Duration > 3000 {
+		t.Fatalf("run at 1x took %dms of simulated time, expected about 2s", base.Duration)
+	}
+	for speed, summary := range summaries {
+		// Runs end on the first metrics tick after the last response
+		if diff := summary.Duration - base.Duration; diff < -100 || diff > 100 {
+			t.Errorf("run at %vx took %dms of simulated time, %dms at 1x", speed, summary.Duration, base.Duration)
+		}
+		if summary.Latency.P99 > ProcessingTimeUpper+2*DatabaseProcessingTimeUpper {
+			t.Errorf("run at %vx had a p99 latency of %dms", speed, summary.Latency.P99)
+		}
+	}
+}
+
+func TestSleepWaitsForHolders(t *testing.T) {
+	clock := NewClock()
+	clock.Reset()
+	defer clock.Stop()
+	err := clock.SetSpeed(MaxSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A holder that takes a while in wall time must not let the sleeper's
+	// deadline pass
+	clock.Hold()
+	clock.Hold()
+	woken := make(chan time.Duration)
+	go func() {
+		err := clock.Sleep(ctx, 10*time.Millisecond)
+		if err == nil {
+			woken <- clock.Elapsed()
+			clock.Release()
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if elapsed := clock.Elapsed(); elapsed != 0 {
+		t.Fatalf("time moved on to %v while held", elapsed)
+	}
+	clock.Release()
+
+	select {
+	case elapsed := <-woken:
+		if elapsed != 10*time.Millisecond {
+			t.Errorf("sleeper woke at %v, expected 10ms", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sleeper was not woken")
+	}
+}
+
+func TestTickerDoesNotDrift(t *testing.T) {
+	clock := NewClock()
+	clock.Reset()
+	defer clock.Stop()
+	err := clock.SetSpeed(MaxSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticker := clock.NewTicker(ctx, 10*time.Millisecond)
+	for i := 1; i <= 20; i++ {
+		select {
+		case <-ticker.C:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tick %d did not arrive", i)
+		}
+		if elapsed := clock.Elapsed(); elapsed != time.Duration(i)*10*time.Millisecond {
+			t.Fatalf("tick %d arrived at %v", i, elapsed)
+		}
+		clock.Release()
+	}
+}
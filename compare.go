@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrInvalidComparison = errors.New("invalid comparison")
+
+// MaxComparedRuns is how many runs each side of a comparison can pool.
+const MaxComparedRuns = 20
+
+const (
+	SignificantChange   string = "significant"
+	InsignificantChange string = "not significant"
+	// UnknownSignificance is given when a side has a single run, so the
+	// spread between seeds cannot be told from the change.
+	UnknownSignificance string = "unknown"
+)
+
+// tCritical holds the two-sided 95% critical values of Student's t
+// distribution by degrees of freedom, from 1 to 30. Beyond that the normal
+// value of 1.96 is close enough.
+var tCritical = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// RunRef names a run of a system, written as "systemID/runID".
+type RunRef struct {
+	SystemID string `json:"systemId"`
+	RunID    string `json:"runId"`
+	Seed     int64  `json:"seed,omitempty"`
+}
+
+func ParseRunRef(value string) (RunRef, error) {
+	systemID, runID, ok := strings.Cut(value, "/")
+	if !ok || systemID == "" || runID == "" {
+		return RunRef{}, fmt.Errorf("%w: run %q should be written as systemID/runID", ErrInvalidComparison, value)
+	}
+	return RunRef{SystemID: systemID, RunID: runID}, nil
+}
+
+// RunComparison sets the runs of a candidate design against those of a
+// baseline, overall and node by node. Each side pools one or more runs of
+// one system, typically with different seeds, and is compared by its mean.
+type RunComparison struct {
+	Baseline  []RunRef         `json:"baseline"`
+	Candidate []RunRef         `json:"candidate"`
+	Overall   []MetricDelta    `json:"overall"`
+	Nodes     []NodeComparison `json:"nodes"`
+}
+
+// NodeComparison compares a node of the baseline with its counterpart in the
+// candidate. A node found on one side only has no metrics, just the ID it
+// has there.
+type NodeComparison struct {
+	Type        string        `json:"type"`
+	BaselineID  string        `json:"baselineId,omitempty"`
+	CandidateID string        `json:"candidateId,omitempty"`
+	Metrics     []MetricDelta `json:"metrics,omitempty"`
+}
+
+// MetricDelta is the change in one metric from the baseline to the
+// candidate. Change is a percentage of the baseline, left out when the
+// baseline is zero.
+type MetricDelta struct {
+	Name         string   `json:"name"`
+	Unit         string   `json:"unit"`
+	Baseline     float64  `json:"baseline"`
+	Candidate    float64  `json:"candidate"`
+	Delta        float64  `json:"delta"`
+	Change       *float64 `json:"change,omitempty"`
+	Significance string   `json:"significance"`
+}
+
+// CompareRuns compares the candidate runs with the baseline runs. Every run
+// must have ended, and the runs of each side must be of the same system.
+func CompareRuns(baseline []RunRecord, candidate []RunRecord) (RunComparison, error) {
+	for _, side := range [][]RunRecord{baseline, candidate} {
+		err := checkComparedRuns(side)
+		if err != nil {
+			return RunComparison{}, err
+		}
+	}
+
+	comparison := RunComparison{
+		Baseline:  runRefs(baseline),
+		Candidate: runRefs(candidate),
+		Overall: compareMetrics(
+			overallSamples(baseline),
+			overallSamples(candidate),
+		),
+		Nodes: []NodeComparison{},
+	}
+
+	for _, pair := range pairNodes(baseline[0].Design.Nodes, candidate[0].Design.Nodes) {
+		node := NodeComparison{
+			Type:        pair.nodeType,
+			BaselineID:  pair.baselineID,
+			CandidateID: pair.candidateID,
+		}
+		if pair.baselineID != "" && pair.candidateID != "" {
+			node.Metrics = compareMetrics(
+				nodeSamples(baseline, pair.baselineID),
+				nodeSamples(candidate, pair.candidateID),
+			)
+		}
+		comparison.Nodes = append(comparison.Nodes, node)
+	}
+
+	return comparison, nil
+}
+
+func checkComparedRuns(records []RunRecord) error {
+	if len(records) == 0 {
+		return fmt.Errorf("%w: both sides need at least one run", ErrInvalidComparison)
+	}
+	if len(records) > MaxComparedRuns {
+		return fmt.Errorf("%w: a side can pool at most %d runs", ErrInvalidComparison, MaxComparedRuns)
+	}
+
+	for _, record := range records {
+		if record.SystemID != records[0].SystemID {
+			return fmt.Errorf("%w: runs %s and %s are of different systems", ErrInvalidComparison, records[0].ID, record.ID)
+		}
+		if record.Summary == nil {
+			return fmt.Errorf("%w: run %s has not ended", ErrInvalidComparison, record.ID)
+		}
+	}
+	return nil
+}
+
+func runRefs(records []RunRecord) []RunRef {
+	refs := []RunRef{}
+	for _, record := range records {
+		refs = append(refs, RunRef{SystemID: record.SystemID, RunID: record.ID, Seed: record.Seed})
+	}
+	return refs
+}
+
+// metricSamples holds the values a metric took in each run of a side.
+type metricSamples struct {
+	metric Metric
+	values []float64
+}
+
+func newSamples(metric Metric, name string) *metricSamples {
+	if name != "" {
+		metric.Name = name
+	}
+	return &metricSamples{metric: metric}
+}
+
+func (m *metricSamples) add(value float64) {
+	m.values = append(m.values, value)
+}
+
+func overallSamples(records []RunRecord) []*metricSamples {
+	p50 := newSamples(NewAvgLatency(0), "p50 Latency")
+	p99 := newSamples(NewAvgLatency(0), "p99 Latency")
+	throughput := newSamples(Metric{Unit: "reqs/s"}, "Throughput")
+	errorRate := newSamples(NewErrorRate(0), "")
+
+	for _, record := range records {
+		summary := record.Summary
+		p50.add(float64(summary.Latency.P50))
+		p99.add(float64(summary.Latency.P99))
+		throughput.add(summary.Throughput)
+		errorRate.add(summary.ErrorRate)
+	}
+	return []*metricSamples{p50, p99, throughput, errorRate}
+}
+
+// nodeSamples collects a node's metrics from every run that has it, leaving
+// out those the node does not measure.
+func nodeSamples(records []RunRecord, nodeID string) []*metricSamples {
+	p50 := newSamples(NewAvgLatency(0), "p50 Latency")
+	p99 := newSamples(NewAvgLatency(0), "p99 Latency")
+	throughput := newSamples(Metric{Unit: "reqs/s"}, "Throughput")
+	errorRate := newSamples(NewErrorRate(0), "")
+	utilisation := newSamples(NewUtilisation(0), "")
+	queued := newSamples(NewQueued(0), "")
+
+	for _, record := range records {
+		for _, node := range record.Summary.Nodes {
+			if node.NodeID != nodeID {
+				continue
+			}
+
+			throughput.add(node.Throughput)
+			errorRate.add(node.ErrorRate)
+			if node.Latency != nil {
+				p50.add(float64(node.Latency.P50))
+				p99.add(float64(node.Latency.P99))
+			}
+			if node.Load != nil {
+				utilisation.add(node.Load.Utilisation)
+				queued.add(node.Load.Queued)
+			}
+		}
+	}
+
+	samples := []*metricSamples{}
+	for _, sample := range []*metricSamples{p50, p99, throughput, errorRate, utilisation, queued} {
+		if len(sample.values) > 0 {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// compareMetrics pairs up the metrics measured on both sides.
+func compareMetrics(baseline []*metricSamples, candidate []*metricSamples) []MetricDelta {
+	deltas := []MetricDelta{}
+	for _, before := range baseline {
+		for _, after := range candidate {
+			if before.metric.Name == after.metric.Name {
+				deltas = append(deltas, NewMetricDelta(before.metric, before.values, after.values))
+			}
+		}
+	}
+	return deltas
+}
+
+func NewMetricDelta(metric Metric, baseline []float64, candidate []float64) MetricDelta {
+	before, beforeVariance := meanVariance(baseline)
+	after, afterVariance := meanVariance(candidate)
+
+	delta := MetricDelta{
+		Name:         metric.Name,
+		Unit:         metric.Unit,
+		Baseline:     round2(before),
+		Candidate:    round2(after),
+		Delta:        round2(after - before),
+		Significance: UnknownSignificance,
+	}
+	if before != 0 {
+		change := round2((after - before) / math.Abs(before) * 100)
+		delta.Change = &change
+	}
+
+	if len(baseline) >= 2 && len(candidate) >= 2 {
+		delta.Significance = InsignificantChange
+		if welchSignificant(before, beforeVariance, len(baseline), after, afterVariance, len(candidate)) {
+			delta.Significance = SignificantChange
+		}
+	}
+	return delta
+}
+
+// welchSignificant runs Welch's t-test on two samples, telling whether their
+// means differ at the 95% level.
+func welchSignificant(mean1 float64, variance1 float64, n1 int, mean2 float64, variance2 float64, n2 int) bool {
+	if variance1 == 0 && variance2 == 0 {
+		// Every seed gave the same value on both sides
+		return mean1 != mean2
+	}
+	t, df := welchT(mean1, variance1, n1, mean2, variance2, n2)
+
+	critical := 1.96
+	if int(df) < 1 {
+		critical = tCritical[0]
+	} else if int(df) <= len(tCritical) {
+		critical = tCritical[int(df)-1]
+	}
+	return math.Abs(t) > critical
+}
+
+// welchT returns Welch's t statistic for the difference of two means, and
+// its degrees of freedom by the Welch-Satterthwaite equation. At least one
+// variance must be above zero.
+func welchT(mean1 float64, variance1 float64, n1 int, mean2 float64, variance2 float64, n2 int) (float64, float64) {
+	se1 := variance1 / float64(n1)
+	se2 := variance2 / float64(n2)
+
+	t := (mean1 - mean2) / math.Sqrt(se1+se2)
+	df := (se1 + se2) * (se1 + se2) / (se1*se1/float64(n1-1) + se2*se2/float64(n2-1))
+	return t, df
+}
+
+// meanVariance returns the mean and the sample variance of the values.
+func meanVariance(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, squares / float64(len(values)-1)
+}
+
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+type nodePair struct {
+	nodeType    string
+	baselineID  string
+	candidateID string
+}
+
+// pairNodes matches the nodes of two designs. Nodes keep their IDs between
+// runs of a system, so those are matched first. Imports and forks give nodes
+// new IDs but keep the layout, so the rest are matched by type and position.
+// Nodes still without a counterpart are listed on their own rather than
+// guessed at.
+func pairNodes(baseline []NodeRecord, candidate []NodeRecord) []nodePair {
+	pairs := []nodePair{}
+	paired := map[string]bool{}
+
+	candidateIDs := map[string]bool{}
+	for _, node := range candidate {
+		candidateIDs[node.ID] = true
+	}
+	for _, node := range baseline {
+		if candidateIDs[node.ID] {
+			pairs = append(pairs, nodePair{nodeType: node.Type, baselineID: node.ID, candidateID: node.ID})
+			paired[node.ID] = true
+		}
+	}
+
+	type placement struct {
+		nodeType string
+		position Position
+	}
+	unpaired := map[placement][]string{}
+	for _, node := range candidate {
+		if !paired[node.ID] {
+			key := placement{node.Type, node.Position}
+			unpaired[key] = append(unpaired[key], node.ID)
+		}
+	}
+	stacked := map[placement]int{}
+	for _, node := range baseline {
+		if !paired[node.ID] {
+			stacked[placement{node.Type, node.Position}]++
+		}
+	}
+	for _, node := range baseline {
+		if paired[node.ID] {
+			continue
+		}
+
+		pair := nodePair{nodeType: node.Type, baselineID: node.ID}
+		key := placement{node.Type, node.Position}
+		if len(unpaired[key]) == 1 && stacked[key] == 1 {
+			pair.candidateID = unpaired[key][0]
+			delete(unpaired, key)
+			paired[pair.candidateID] = true
+		}
+		pairs = append(pairs, pair)
+	}
+
+	for _, node := range candidate {
+		if !paired[node.ID] {
+			pairs = append(pairs, nodePair{nodeType: node.Type, candidateID: node.ID})
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPairNodes(t *testing.T) {
+	baseline := []NodeRecord{
+		{ID: "b-server-2", Type: ServerType, Position: Position{X: 400, Y: 200}},
+		{ID: "client", Type: ClientType, Position: Position{X: 0, Y: 0}},
+		{ID: "b-server-1", Type: ServerType, Position: Position{X: 400, Y: 0}},
+		{ID: "b-database", Type: DatabaseType, Position: Position{X: 800, Y: 0}},
+	}
+	candidate := []NodeRecord{
+		{ID: "c-server-1", Type: ServerType, Position: Position{X: 400, Y: 0}},
+		{ID: "c-server-3", Type: ServerType, Position: Position{X: 400, Y: 400}},
+		{ID: "client", Type: ClientType, Position: Position{X: 50, Y: 50}},
+		{ID: "c-server-2", Type: ServerType, Position: Position{X: 400, Y: 200}},
+		{ID: "c-database", Type: DatabaseType, Position: Position{X: 800, Y: 100}},
+	}
+
+	expected := []nodePair{
+		{nodeType: ClientType, baselineID: "client", candidateID: "client"},
+		{nodeType: ServerType, baselineID: "b-server-2", candidateID: "c-server-2"},
+		{nodeType: ServerType, baselineID: "b-server-1", candidateID: "c-server-1"},
+		{nodeType: DatabaseType, baselineID: "b-database"},
+		{nodeType: ServerType, candidateID: "c-server-3"},
+		{nodeType: DatabaseType, candidateID: "c-database"},
+	}
+	pairs := pairNodes(baseline, candidate)
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("got pairs %+v, expected %+v", pairs, expected)
+	}
+}
+
+func TestPairNodesLeavesStackedNodesUnpaired(t *testing.T) {
+	baseline := []NodeRecord{
+		{ID: "b-server-1", Type: ServerType},
+		{ID: "b-server-2", Type: ServerType},
+	}
+	candidate := []NodeRecord{
+		{ID: "c-server-1", Type: ServerType},
+	}
+
+	expected := []nodePair{
+		{nodeType: ServerType, baselineID: "b-server-1"},
+		{nodeType: ServerType, baselineID: "b-server-2"},
+		{nodeType: ServerType, candidateID: "c-server-1"},
+	}
+	pairs := pairNodes(baseline, candidate)
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("got pairs %+v, expected %+v", pairs, expected)
+	}
+}
+
+func TestWelchT(t *testing.T) {
+	// The examples of Welch's t-test on Wikipedia, with the t, degrees of
+	// freedom and two-sided p values given there to two or three figures
+	tests := []struct {
+		baseline    []float64
+		candidate   []float64
+		t           float64
+		df          float64
+		p           float64
+		significant bool
+	}{
+		{
+			baseline:    []float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4},
+			candidate:   []float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4},
+			t:           -2.46,
+			df:          25.0,
+			p:           0.021,
+			significant: true,
+		},
+		{
+			baseline:    []float64{17.2, 20.9, 22.6, 18.1, 21.7, 21.4, 23.5, 24.2, 14.7, 21.8},
+			candidate:   []float64{21.5, 22.8, 21.0, 23.0, 21.6, 23.6, 22.5, 20.7, 23.4, 21.8, 20.7, 21.7, 21.5, 22.5, 23.6, 21.5, 22.5, 23.5, 21.5, 21.8},
+			t:           -1.57,
+			df:          9.9,
+			p:           0.149,
+			significant: false,
+		},
+		{
+			baseline:    []float64{19.8, 20.4, 19.6, 17.8, 18.5, 18.9, 18.3, 18.9, 19.5, 22.0},
+			candidate:   []float64{28.2, 26.6, 20.1, 23.3, 25.2, 22.1, 17.7, 27.6, 20.6, 13.7, 23.2, 17.5, 20.6, 18.0, 23.9, 21.6, 24.3, 20.4, 23.9, 13.3},
+			t:           -2.22,
+			df:          24.5,
+			p:           0.036,
+			significant: true,
+		},
+	}
+
+	for i, test := range tests {
+		mean1, variance1 := meanVariance(test.baseline)
+		mean2, variance2 := meanVariance(test.candidate)
+		statistic, df := welchT(mean1, variance1, len(test.baseline), mean2, variance2, len(test.candidate))
+		if math.Abs(statistic-test.t) > 0.01 || math.Abs(df-test.df) > 0.05 {
+			t.Errorf("example %d: got t = %.3f with %.2f degrees of freedom, expected %.2f with %.1f", i+1, statistic, df, test.t, test.df)
+		}
+
+		significant := welchSignificant(mean1, variance1, len(test.baseline), mean2, variance2, len(test.candidate))
+		if significant != test.significant {
+			t.Errorf("example %d: with p = %v, significant was %v", i+1, test.p, significant)
+		}
+	}
+}
+
+func TestWelchSignificant(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseline    []float64
+		candidate   []float64
+		significant bool
+	}{
+		// t = 5 with 8 degrees of freedom, p = 0.001
+		{"far apart", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, true},
+		// t = 1 with 8 degrees of freedom, p = 0.35
+		{"overlapping", []float64{1, 2, 3, 4, 5}, []float64{2, 3, 4, 5, 6}, false},
+		// Either side of the critical t of 2.306 at 8 degrees of freedom
+		{"just below critical", []float64{1, 2, 3, 4, 5}, []float64{3.3, 4.3, 5.3, 6.3, 7.3}, false},
+		{"just above critical", []float64{1, 2, 3, 4, 5}, []float64{3.31, 4.31, 5.31, 6.31, 7.31}, true},
+		{"same constant", []float64{7, 7, 7}, []float64{7, 7}, false},
+		{"different constants", []float64{7, 7, 7}, []float64{8, 8}, true},
+		{"one side constant", []float64{5, 5, 5}, []float64{4, 5, 6}, false},
+	}
+
+	for _, test := range tests {
+		mean1, variance1 := meanVariance(test.baseline)
+		mean2, variance2 := meanVariance(test.candidate)
+		significant := welchSignificant(mean1, variance1, len(test.baseline), mean2, variance2, len(test.candidate))
+		if significant != test.significant {
+			t.Errorf("%s: significant was %v", test.name, significant)
+		}
+	}
+}
+
+func TestMetricDeltaSignificance(t *testing.T) {
+	metric := NewAvgLatency(0)
+
+	// A single seed on either side cannot tell the change from the spread
+	// between seeds
+	for _, sides := range [][2][]float64{
+		{{100}, {200}},
+		{{100}, {200, 210}},
+		{{100, 110}, {200}},
+	} {
+		delta := NewMetricDelta(metric, sides[0], sides[1])
+		if delta.Significance != UnknownSignificance {
+			t.Errorf("%v against %v was %s", sides[0], sides[1], delta.Significance)
+		}
+	}
+
+	delta := NewMetricDelta(metric, []float64{100, 110}, []float64{200, 210})
+	if delta.Significance != SignificantChange || delta.Delta != 100 || delta.Change == nil || *delta.Change != 95.24 {
+		t.Errorf("got delta %+v", delta)
+	}
+
+	delta = NewMetricDelta(metric, []float64{0, 0}, []float64{5, 5})
+	if delta.Change != nil || delta.Significance != SignificantChange {
+		t.Errorf("got delta %+v from a baseline of zero", delta)
+	}
+}
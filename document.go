@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/lithammer/shortuuid/v3"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+const DocumentVersion = 1
+
+const (
+	JSONFormat string = "json"
+	YAMLFormat string = "yaml"
+)
+
+// SystemDocument is the portable, versioned form of a design. Node IDs are
+// only references within the document and are replaced on import.
+type SystemDocument struct {
+	Version     int            `json:"version" yaml:"version"`
+	Name        string         `json:"name,omitempty" yaml:"name,omitempty"`
+	Description string         `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string       `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Workload    Workload       `json:"workload" yaml:"workload"`
+	Seed        int64          `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Nodes       []NodeDocument `json:"nodes" yaml:"nodes"`
+	Edges       []EdgeDocument `json:"edges" yaml:"edges"`
+}
+
+type NodeDocument struct {
+	ID       string     `json:"id" yaml:"id"`
+	Type     string     `json:"type" yaml:"type"`
+	Position Position   `json:"position" yaml:"position"`
+	Config   NodeConfig `json:"config,omitempty" yaml:"config,omitempty"`
+}
+
+type EdgeDocument struct {
+	Source string `json:"source" yaml:"source"`
+	Target string `json:"target" yaml:"target"`
+}
+
+func NewSystemDocument(record SystemRecord) SystemDocument {
+	document := SystemDocument{
+		Version:     DocumentVersion,
+		Name:        record.Metadata.Name,
+		Description: record.Metadata.Description,
+		Tags:        record.Metadata.Tags,
+		Workload:    record.Workload,
+		Seed:        record.Seed,
+		Nodes:       []NodeDocument{},
+		Edges:       []EdgeDocument{},
+	}
+
+	for _, node := range record.Nodes {
+		document.Nodes = append(document.Nodes, NodeDocument{
+			ID:       node.ID,
+			Type:     node.Type,
+			Position: node.Position,
+			Config:   node.Config,
+		})
+	}
+	for _, edge := range record.Edges {
+		document.Edges = append(document.Edges, EdgeDocument{
+			Source: edge.Source,
+			Target: edge.Target,
+		})
+	}
+
+	return document
+}
+
+// Record checks the document and turns it into a record for a new system,
+// with fresh system, node and edge IDs.
+func (d SystemDocument) Record() (SystemRecord, error) {
+	if d.Version != DocumentVersion {
+		return SystemRecord{}, fmt.Errorf("unsupported document version %d, expected %d", d.Version, DocumentVersion)
+	}
+
+	record := SystemRecord{
+		ID: shortuuid.New(),
+		Metadata: Metadata{
+			Name:        d.Name,
+			Description: d.Description,
+			Tags:        d.Tags,
+		},
+		Workload: d.Workload,
+		Seed:     d.Seed,
+		Nodes:    []NodeRecord{},
+		Edges:    []EdgeRecord{},
+	}
+
+	nodeIDs := map[string]string{}
+	for i, node := range d.Nodes {
+		if node.ID == "" {
+			return SystemRecord{}, fmt.Errorf("nodes[%d] has no id", i)
+		}
+		if _, exists := nodeIDs[node.ID]; exists {
+			return SystemRecord{}, fmt.Errorf("nodes[%d] reuses id %q", i, node.ID)
+		}
+		if !IsNodeType(node.Type) {
+			return SystemRecord{}, fmt.Errorf("nodes[%d] has unknown type %q", i, node.Type)
+		}
+
+		nodeIDs[node.ID] = shortuuid.New()
+		record.Nodes = append(record.Nodes, NodeRecord{
+			ID:       nodeIDs[node.ID],
+			Type:     node.Type,
+			Position: node.Position,
+			Config:   node.Config,
+		})
+	}
+
+	for i, edge := range d.Edges {
+		source, ok := nodeIDs[edge.Source]
+		if !ok {
+			return SystemRecord{}, fmt.Errorf("edges[%d] has unknown source %q", i, edge.Source)
+		}
+		target, ok := nodeIDs[edge.Target]
+		if !ok {
+			return SystemRecord{}, fmt.Errorf("edges[%d] has unknown target %q", i, edge.Target)
+		}
+
+		record.Edges = append(record.Edges, EdgeRecord{
+			ID:     shortuuid.New(),
+			Source: source,
+			Target: target,
+		})
+	}
+
+	return record, nil
+}
+
+// DocumentFormat picks YAML when the hint mentions it and JSON otherwise.
+// Hints are format query parameters or Accept and Content-Type headers.
+func DocumentFormat(hints ...string) string {
+	for _, hint := range hints {
+		if strings.Contains(strings.ToLower(hint), YAMLFormat) {
+			return YAMLFormat
+		}
+	}
+	return JSONFormat
+}
+
+func EncodeSystemDocument(document SystemDocument, format string) ([]byte, error) {
+	if format == YAMLFormat {
+		var buffer bytes.Buffer
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err := encoder.Encode(document)
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Close()
+		return buffer.Bytes(), err
+	}
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// DecodeSystemDocument parses a document, rejecting fields the schema does
+// not know so that typos do not silently fall back to defaults.
+func DecodeSystemDocument(data []byte, format string) (SystemDocument, error) {
+	var document SystemDocument
+
+	if format == YAMLFormat {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err := decoder.Decode(&document)
+		return document, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&document)
+	return document, err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDocumentRoundTrip(t *testing.T) {
+	system, err := CompileDSL("workload {numRequests: 50, requestInterval: 5}\nseed 7\nclient -> lb -> [server x2 {maxRoutines: 4}] -> db", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+	original := system.Record()
+	original.Metadata.Name = "shop"
+	original.Metadata.Tags = []string{"demo"}
+
+	for _, format := range []string{JSONFormat, YAMLFormat} {
+		documentBytes, err := EncodeSystemDocument(NewSystemDocument(original), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		document, err := DecodeSystemDocument(documentBytes, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		imported, err := document.Record()
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		if imported.ID == original.ID {
+			t.Errorf("%s: import kept the system ID", format)
+		}
+		if imported.Metadata.Name != "shop" || !reflect.DeepEqual(imported.Metadata.Tags, []string{"demo"}) {
+			t.Errorf("%s: imported metadata %+v", format, imported.Metadata)
+		}
+		if imported.Workload != original.Workload || imported.Seed != original.Seed {
+			t.Errorf("%s: imported workload %+v and seed %d", format, imported.Workload, imported.Seed)
+		}
+
+		// Node IDs are replaced, so compare the nodes in order and the edges
+		// through the old to new ID mapping
+		if len(imported.Nodes) != len(original.Nodes) || len(imported.Edges) != len(original.Edges) {
+			t.Fatalf("%s: imported %d nodes and %d edges", format, len(imported.Nodes), len(imported.Edges))
+		}
+		nodeIDs := map[string]string{}
+		for i, node := range imported.Nodes {
+			want := original.Nodes[i]
+			if node.ID == want.ID || node.Type != want.Type || node.Position != want.Position || node.Config != want.Config {
+				t.Errorf("%s: imported node %+v from %+v", format, node, want)
+			}
+			nodeIDs[want.ID] = node.ID
+		}
+		for i, edge := range imported.Edges {
+			want := original.Edges[i]
+			if edge.Source != nodeIDs[want.Source] || edge.Target != nodeIDs[want.Target] {
+				t.Errorf("%s: imported edge %+v from %+v", format, edge, want)
+			}
+		}
+	}
+}
+
+func TestDocumentRejects(t *testing.T) {
+	tests := []struct {
+		format   string
+		document string
+		message  string
+	}{
+		{JSONFormat, `{"version": 2, "nodes": [], "edges": []}`, "unsupported document version 2"},
+		{YAMLFormat, "version: 0\nnodes: []\nedges: []\n", "unsupported document version 0"},
+		{JSONFormat, `{"version": 1, "nodes": [], "edges": [], "colour": "red"}`, "unknown field"},
+		{YAMLFormat, "version: 1\nnodes: []\nedges: []\ncolour: red\n", "not found"},
+		{JSONFormat, `{"version": 1, "nodes": [{"id": "a", "type": "mainframe"}], "edges": []}`, "unknown type"},
+		{JSONFormat, `{"version": 1, "nodes": [{"id": "a", "type": "client"}], "edges": [{"source": "a", "target": "b"}]}`, "unknown target"},
+	}
+	for _, test := range tests {
+		document, err := DecodeSystemDocument([]byte(test.document), test.format)
+		if err == nil {
+			_, err = document.Record()
+		}
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s %q returned %v, expected %q", test.format, test.document, err, test.message)
+		}
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The design DSL describes a system as chains of nodes, one statement per
+// line or separated by semicolons:
+//
+//	# a reporting server shares the load balancer and database
+//	workload {numRequests: 500, requestInterval: 5}
+//	seed 42
+//	client -> lb front -> [server x3 {maxRoutines: 50}] -> db store
+//	front -> server reports -> store
+//
+// A node is a type (client, lb, queue, server, cache or db), an optional
+// name, an optional xN count and an optional config block. Naming a node lets
+// later statements refer to it; unnamed nodes are new on every mention.
+// Brackets group nodes, and an arrow connects every node on its left to every
+// node on its right.
+
+// DSLError points at the line and column of the DSL source it is about.
+type DSLError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (e *DSLError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+const (
+	dslIdent = iota
+	dslInt
+	dslArrow
+	dslOpenBracket
+	dslCloseBracket
+	dslOpenBrace
+	dslCloseBrace
+	dslColon
+	dslComma
+	dslEnd
+	dslEOF
+)
+
+var dslTokenNames = map[int]string{
+	dslIdent:        "name",
+	dslInt:          "number",
+	dslArrow:        `"->"`,
+	dslOpenBracket:  `"["`,
+	dslCloseBracket: `"]"`,
+	dslOpenBrace:    `"{"`,
+	dslCloseBrace:   `"}"`,
+	dslColon:        `":"`,
+	dslComma:        `","`,
+	dslEnd:          "end of statement",
+	dslEOF:          "end of input",
+}
+
+// dslNodeTypes maps the type keywords of the DSL to node types.
+var dslNodeTypes = map[string]string{
+	"client":       ClientType,
+	"lb":           LoadBalancerType,
+	"loadbalancer": LoadBalancerType,
+	"server":       ServerType,
+	"db":           DatabaseType,
+	"database":     DatabaseType,
+	"cache":        CacheType,
+	"queue":        QueueType,
+}
+
+type dslToken struct {
+	kind   int
+	text   string
+	line   int
+	column int
+}
+
+func (t dslToken) describe() string {
+	if t.kind == dslIdent || t.kind == dslInt {
+		return fmt.Sprintf("%s %q", dslTokenNames[t.kind], t.text)
+	}
+	return dslTokenNames[t.kind]
+}
+
+func lexDSL(source string) ([]dslToken, error) {
+	tokens := []dslToken{}
+	runes := []rune(source)
+	line, column := 1, 1
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		token := dslToken{line: line, column: column}
+
+		switch {
+		case r == '\n' || r == ';':
+			token.kind = dslEnd
+			token.text = string(r)
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+				column++
+			}
+			continue
+		case unicode.IsSpace(r):
+			i++
+			column++
+			continue
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '>':
+			token.kind = dslArrow
+			token.text = "->"
+		case strings.ContainsRune("[]{}:,", r):
+			token.kind = map[rune]int{
+				'[': dslOpenBracket,
+				']': dslCloseBracket,
+				'{': dslOpenBrace,
+				'}': dslCloseBrace,
+				':': dslColon,
+				',': dslComma,
+			}[r]
+			token.text = string(r)
+		case unicode.IsDigit(r):
+			token.kind = dslInt
+			j := i
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			token.text = string(runes[i:j])
+		case unicode.IsLetter(r) || r == '_':
+			token.kind = dslIdent
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '-') {
+				// A dash may be part of a name, but not the start of an arrow
+				if runes[j] == '-' && j+1 < len(runes) && runes[j+1] == '>' {
+					break
+				}
+				j++
+			}
+			token.text = string(runes[i:j])
+		default:
+			return nil, &DSLError{Line: line, Column: column, Message: fmt.Sprintf("unexpected character %q", r)}
+		}
+
+		tokens = append(tokens, token)
+		length := len([]rune(token.text))
+		i += length
+		if token.kind == dslEnd && r == '\n' {
+			line++
+			column = 1
+		} else {
+			column += length
+		}
+	}
+
+	return append(tokens, dslToken{kind: dslEOF, line: line, column: column}), nil
+}
+
+// DSLDesign is a parsed DSL source, ready to be compiled into a system.
+type DSLDesign struct {
+	Workload *Workload
+	Seed     int64
+	Nodes    []DSLNode
+	Edges    [][2]int
+}
+
+// DSLNode is one node of a design, with the position it was declared at.
+type DSLNode struct {
+	Name   string
+	Type   string
+	Config NodeConfig
+	Line   int
+	Column int
+}
+
+type dslParser struct {
+	tokens   []dslToken
+	position int
+	design   DSLDesign
+	names    map[string][]int
+	edges    map[[2]int]bool
+}
+
+func ParseDSL(source string) (DSLDesign, error) {
+	tokens, err := lexDSL(source)
+	if err != nil {
+		return DSLDesign{}, err
+	}
+
+	p := &dslParser{
+		tokens: tokens,
+		names:  map[string][]int{},
+		edges:  map[[2]int]bool{},
+	}
+	for p.peek().kind != dslEOF {
+		if p.peek().kind == dslEnd {
+			p.next()
+			continue
+		}
+
+		err = p.statement()
+		if err != nil {
+			return DSLDesign{}, err
+		}
+	}
+
+	if len(p.design.Nodes) == 0 {
+		return DSLDesign{}, &DSLError{Line: 1, Column: 1, Message: "design has no nodes"}
+	}
+	return p.design, nil
+}
+
+func (p *dslParser) peek() dslToken {
+	return p.tokens[p.position]
+}
+
+func (p *dslParser) next() dslToken {
+	token := p.tokens[p.position]
+	if token.kind != dslEOF {
+		p.position++
+	}
+	return token
+}
+
+func (p *dslParser) expect(kind int) (dslToken, error) {
+	token := p.next()
+	if token.kind != kind {
+		return token, p.errorf(token, "expected %s, found %s", dslTokenNames[kind], token.describe())
+	}
+	return token, nil
+}
+
+func (p *dslParser) errorf(token dslToken, format string, args ...interface{}) error {
+	return &DSLError{Line: token.line, Column: token.column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *dslParser) statement() error {
+	first := p.peek()
+	following := first
+	if first.kind != dslEOF {
+		following = p.tokens[p.position+1]
+	}
+
+	switch {
+	case first.kind == dslIdent && first.text == "workload" && following.kind == dslOpenBrace:
+		return p.workload()
+	case first.kind == dslIdent && first.text == "seed" && following.kind == dslInt:
+		p.next()
+		seed, err := strconv.ParseInt(p.next().text, 10, 64)
+		if err != nil {
+			return p.errorf(following, "seed %s is out of range", following.text)
+		}
+		p.design.Seed = seed
+		return p.endStatement()
+	}
+
+	previous, err := p.group()
+	if err != nil {
+		return err
+	}
+	for p.peek().kind == dslArrow {
+		p.next()
+		targetToken := p.peek()
+		targets, err := p.group()
+		if err != nil {
+			return err
+		}
+
+		for _, source := range previous {
+			for _, target := range targets {
+				if p.design.Nodes[target].Type == ClientType {
+					return p.errorf(targetToken, "%s cannot receive requests", ClientType)
+				}
+				if source == target {
+					return p.errorf(targetToken, "node cannot connect to itself")
+				}
+
+				edge := [2]int{source, target}
+				if !p.edges[edge] {
+					p.edges[edge] = true
+					p.design.Edges = append(p.design.Edges, edge)
+				}
+			}
+		}
+		previous = targets
+	}
+
+	return p.endStatement()
+}
+
+func (p *dslParser) endStatement() error {
+	token := p.next()
+	if token.kind != dslEnd && token.kind != dslEOF {
+		return p.errorf(token, "expected \"->\" or end of statement, found %s", token.describe())
+	}
+	return nil
+}
+
+func (p *dslParser) workload() error {
+	keyword := p.next()
+	p.next()
+	fields, err := p.block()
+	if err != nil {
+		return err
+	}
+
+	workload := DefaultWorkload()
+	for _, field := range fields {
+		switch field.key.text {
+		case "numRequests":
+			workload.NumRequests = field.value
+		case "requestInterval":
+			workload.RequestInterval = field.value
+		default:
+			return p.errorf(field.key, "unknown workload field %q, expected numRequests or requestInterval", field.key.text)
+		}
+	}
+	if err = workload.Validate(); err != nil {
+		return p.errorf(keyword, "%s", err)
+	}
+	p.design.Workload = &workload
+
+	return p.endStatement()
+}
+
+// group parses a node or a bracketed list of nodes and returns their indexes.
+func (p *dslParser) group() ([]int, error) {
+	if p.peek().kind != dslOpenBracket {
+		return p.node()
+	}
+	p.next()
+
+	nodes := []int{}
+	for {
+		members, err := p.node()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, members...)
+
+		token := p.next()
+		if token.kind == dslCloseBracket {
+			return nodes, nil
+		}
+		if token.kind != dslComma {
+			return nil, p.errorf(token, "expected \",\" or \"]\", found %s", token.describe())
+		}
+	}
+}
+
+func (p *dslParser) node() ([]int, error) {
+	typeToken, err := p.expect(dslIdent)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeType, isType := dslNodeTypes[strings.ToLower(typeToken.text)]
+	if !isType {
+		nodes, ok := p.names[typeToken.text]
+		if !ok {
+			return nil, p.errorf(typeToken, "unknown node type or name %q, expected one of client, lb, queue, server, cache or db", typeToken.text)
+		}
+		if next := p.peek(); next.kind == dslOpenBrace || next.kind == dslIdent {
+			return nil, p.errorf(next, "%q is already defined, refer to it by name alone", typeToken.text)
+		}
+		return nodes, nil
+	}
+
+	name := ""
+	if token := p.peek(); token.kind == dslIdent && !isDSLCount(token.text) {
+		p.next()
+		if _, exists := p.names[token.text]; exists {
+			return nil, p.errorf(token, "name %q is already defined", token.text)
+		}
+		if _, reserved := dslNodeTypes[strings.ToLower(token.text)]; reserved {
+			return nil, p.errorf(token, "name %q is a node type", token.text)
+		}
+		name = token.text
+	}
+
+	count := 1
+	if token := p.peek(); token.kind == dslIdent && isDSLCount(token.text) {
+		p.next()
+		count, err = strconv.Atoi(token.text[1:])
+		if err != nil || count < 1 || count > 100 {
+			return nil, p.errorf(token, "count %s must be between x1 and x100", token.text)
+		}
+	}
+
+	config := NodeConfig{}
+	if p.peek().kind == dslOpenBrace {
+		p.next()
+		fields, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			switch field.key.text {
+			case "maxRoutines":
+				config.MaxRoutines = field.value
+			case "processingTimeLower":
+				config.ProcessingTimeLower = field.value
+			case "processingTimeUpper":
+				config.ProcessingTimeUpper = field.value
+			case "hitRate":
+				config.HitRate = field.value
+			default:
+				return nil, p.errorf(field.key, "unknown config field %q, expected maxRoutines, processingTimeLower, processingTimeUpper or hitRate", field.key.text)
+			}
+		}
+	}
+
+	nodes := []int{}
+	for i := 0; i < count; i++ {
+		nodeName := name
+		if name != "" && count > 1 {
+			nodeName = fmt.Sprintf("%s%d", name, i+1)
+		}
+
+		nodes = append(nodes, len(p.design.Nodes))
+		p.design.Nodes = append(p.design.Nodes, DSLNode{
+			Name:   nodeName,
+			Type:   nodeType,
+			Config: config,
+			Line:   typeToken.line,
+			Column: typeToken.column,
+		})
+	}
+	if name != "" {
+		p.names[name] = nodes
+	}
+
+	return nodes, nil
+}
+
+type dslField struct {
+	key   dslToken
+	value int
+}
+
+// block parses the fields of a {key: value, ...} block after its opening brace.
+func (p *dslParser) block() ([]dslField, error) {
+	fields := []dslField{}
+	if p.peek().kind == dslCloseBrace {
+		p.next()
+		return fields, nil
+	}
+
+	for {
+		key, err := p.expect(dslIdent)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if field.key.text == key.text {
+				return nil, p.errorf(key, "field %q is set twice", key.text)
+			}
+		}
+		_, err = p.expect(dslColon)
+		if err != nil {
+			return nil, err
+		}
+		valueToken, err := p.expect(dslInt)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.Atoi(valueToken.text)
+		if err != nil {
+			return nil, p.errorf(valueToken, "number %s is out of range", valueToken.text)
+		}
+		fields = append(fields, dslField{key: key, value: value})
+
+		token := p.next()
+		if token.kind == dslCloseBrace {
+			return fields, nil
+		}
+		if token.kind != dslComma {
+			return nil, p.errorf(token, "expected \",\" or \"}\", found %s", token.describe())
+		}
+	}
+}
+
+func isDSLCount(text string) bool {
+	if len(text) < 2 || text[0] != 'x' {
+		return false
+	}
+	for _, r := range text[1:] {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// CompileDSL parses source and builds a new system from it, laid out left to
+// right in the direction requests flow. Counts fan out into many nodes and
+// edges, so the design is held to the limits before anything is built.
+func CompileDSL(source string, limits Limits) (*System, error) {
+	design, err := ParseDSL(source)
+	if err != nil {
+		return nil, err
+	}
+	err = limits.CheckDesign(len(design.Nodes), len(design.Edges))
+	if err != nil {
+		return nil, err
+	}
+
+	system := NewSystem()
+	if design.Workload != nil {
+		err = system.SetWorkload(*design.Workload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	system.SetSeed(design.Seed)
+
+	positions := LayoutDSL(design)
+	nodeIDs := make([]string, len(design.Nodes))
+	for i, dslNode := range design.Nodes {
+		node, err := system.AddNode(dslNode.Type)
+		if err != nil {
+			return nil, err
+		}
+		nodeIDs[i] = node.GetID()
+
+		err = system.ConfigureNode(node.GetID(), dslNode.Config)
+		if err != nil {
+			return nil, &DSLError{Line: dslNode.Line, Column: dslNode.Column, Message: err.Error()}
+		}
+		err = system.MoveNode(node.GetID(), positions[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, edge := range design.Edges {
+		_, err = system.AddEdge(nodeIDs[edge[0]], nodeIDs[edge[1]])
+		if err != nil {
+			return nil, err
+		}
+	}
+	system.ClearHistory()
+
+	return system, nil
+}
+
+// LayoutDSL places each node in a column by the longest path leading to it,
+// with the nodes of a column spread evenly around the middle of the canvas.
+// Nodes on a cycle go in a column after the others.
+func LayoutDSL(design DSLDesign) []Position {
+	inDegree := make([]int, len(design.Nodes))
+	outgoing := make([][]int, len(design.Nodes))
+	for _, edge := range design.Edges {
+		outgoing[edge[0]] = append(outgoing[edge[0]], edge[1])
+		inDegree[edge[1]]++
+	}
+
+	depths := make([]int, len(design.Nodes))
+	placed := make([]bool, len(design.Nodes))
+	queue := []int{}
+	for i, degree := range inDegree {
+		if degree == 0 {
+			queue = append(queue, i)
+		}
+	}
+	maxDepth := 0
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		placed[node] = true
+		if depths[node] > maxDepth {
+			maxDepth = depths[node]
+		}
+
+		for _, target := range outgoing[node] {
+			if depths[node]+1 > depths[target] {
+				depths[target] = depths[node] + 1
+			}
+			inDegree[target]--
+			if inDegree[target] == 0 {
+				queue = append(queue, target)
+			}
+		}
+	}
+
+	columns := map[int][]int{}
+	for i := range design.Nodes {
+		if !placed[i] {
+			depths[i] = maxDepth + 1
+		}
+		columns[depths[i]] = append(columns[depths[i]], i)
+	}
+
+	positions := make([]Position, len(design.Nodes))
+	for depth, nodes := range columns {
+		sort.Ints(nodes)
+		for row, node := range nodes {
+			positions[node] = Position{
+				X: 400 + depth*325,
+				Y: 425 + (2*row-len(nodes)+1)*100,
+			}
+		}
+	}
+
+	return positions
+}
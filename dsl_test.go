@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDSL(t *testing.T) {
+	design, err := ParseDSL(`workload {numRequests: 500, requestInterval: 5}
+seed 42
+client -> lb front -> [server x2 {maxRoutines: 50}] -> db store
+front -> server reports -> store`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if design.Workload == nil || *design.Workload != (Workload{NumRequests: 500, RequestInterval: 5}) {
+		t.Errorf("got workload %+v", design.Workload)
+	}
+	if design.Seed != 42 {
+		t.Errorf("got seed %d", design.Seed)
+	}
+
+	types := []string{}
+	for _, node := range design.Nodes {
+		types = append(types, node.Type)
+	}
+	expectedTypes := []string{ClientType, LoadBalancerType, ServerType, ServerType, DatabaseType, ServerType}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Errorf("got node types %v, expected %v", types, expectedTypes)
+	}
+	if design.Nodes[2].Config.MaxRoutines != 50 || design.Nodes[3].Config.MaxRoutines != 50 {
+		t.Errorf("config was not applied to every counted node: %+v", design.Nodes[2:4])
+	}
+	if design.Nodes[5].Name != "reports" || design.Nodes[5].Line != 4 || design.Nodes[5].Column != 10 {
+		t.Errorf("got node %+v", design.Nodes[5])
+	}
+
+	expectedEdges := [][2]int{{0, 1}, {1, 2}, {1, 3}, {2, 4}, {3, 4}, {1, 5}, {5, 4}}
+	if !reflect.DeepEqual(design.Edges, expectedEdges) {
+		t.Errorf("got edges %v, expected %v", design.Edges, expectedEdges)
+	}
+}
+
+func TestParseDSLErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		line    int
+		column  int
+		message string
+	}{
+		{"", 1, 1, "design has no nodes"},
+		{"client -> server @", 1, 18, "unexpected character"},
+		{"client -> mainframe", 1, 11, `unknown node type or name "mainframe"`},
+		{"client -> server\nserver -> client", 2, 11, "client cannot receive requests"},
+		{"client -> server {maxRoutines 5}", 1, 31, `expected ":", found number "5"`},
+		{"client -> [server x101]", 1, 19, "count x101 must be between x1 and x100"},
+		{"client a -> server a", 1, 20, `name "a" is already defined`},
+		{"client -> server db", 1, 18, `name "db" is a node type`},
+		{"# comment\n\nclient -> server -> server s -> s", 3, 33, "node cannot connect to itself"},
+		{"client -> server x2 {hitRate: 5} extra", 1, 34, `expected "->" or end of statement, found name "extra"`},
+		{"client -> cache {hitRate: 5, hitRate: 6}", 1, 30, `field "hitRate" is set twice`},
+		{"client -> server\nworkload {numRequests: 0}", 2, 1, "numRequests must be at least 1"},
+		{"client -> [server, db", 1, 22, `expected "," or "]", found end of input`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseDSL(test.source)
+		var dslError *DSLError
+		if !errors.As(err, &dslError) {
+			t.Errorf("parsing %q returned %v, expected a DSL error", test.source, err)
+			continue
+		}
+		if dslError.Line != test.line || dslError.Column != test.column || !strings.Contains(dslError.Message, test.message) {
+			t.Errorf("parsing %q returned %q, expected line %d, column %d: %s", test.source, err, test.line, test.column, test.message)
+		}
+	}
+}
+
+func TestLayoutDSL(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodes     int
+		edges     [][2]int
+		positions []Position
+	}{
+		{
+			name:      "single node",
+			nodes:     1,
+			positions: []Position{{400, 425}},
+		},
+		{
+			name:      "fan out and in",
+			nodes:     5,
+			edges:     [][2]int{{0, 1}, {1, 2}, {1, 3}, {2, 4}, {3, 4}},
+			positions: []Position{{400, 425}, {725, 425}, {1050, 325}, {1050, 525}, {1375, 425}},
+		},
+		{
+			name:      "longest path",
+			nodes:     3,
+			edges:     [][2]int{{0, 1}, {0, 2}, {1, 2}},
+			positions: []Position{{400, 425}, {725, 425}, {1050, 425}},
+		},
+		{
+			name:      "cycle",
+			nodes:     3,
+			edges:     [][2]int{{0, 1}, {1, 2}, {2, 1}},
+			positions: []Position{{400, 425}, {725, 325}, {725, 525}},
+		},
+	}
+
+	for _, test := range tests {
+		design := DSLDesign{Nodes: make([]DSLNode, test.nodes), Edges: test.edges}
+		positions := LayoutDSL(design)
+		if !reflect.DeepEqual(positions, test.positions) {
+			t.Errorf("%s: got positions %v, expected %v", test.name, positions, test.positions)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+const (
+	RequestSentEvent         string = "request sent"
+	RequestForwardedEvent    string = "request forwarded"
+	ProcessingStartedEvent   string = "processing started"
+	ProcessingCompletedEvent string = "processing completed"
+	ProcessingFailedEvent    string = "processing failed"
+	ResponseForwardedEvent   string = "response forwarded"
+	ResponseReceivedEvent    string = "response received"
+)
+
+// Event is a single step a request takes through the system, such as a load
+// balancer forwarding it or a server starting to process it.
+type Event struct {
+	Time        int    `json:"time"`
+	NodeID      string `json:"nodeId"`
+	NodeType    string `json:"nodeType"`
+	Kind        string `json:"kind"`
+	RequestID   int    `json:"requestId"`
+	Description string `json:"description"`
+}
+
+func NewEvent(node Node, kind string, requestID int, description string) Event {
+	return Event{
+		NodeID:      node.GetID(),
+		NodeType:    node.GetType(),
+		Kind:        kind,
+		RequestID:   requestID,
+		Description: description,
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+var ErrInvalidFault = errors.New("invalid fault")
+
+// Fault degrades a node to show how the rest of the system copes. Down nodes
+// fail every request straight away, Latency adds milliseconds to each
+// request's processing time and ErrorRate is the percentage of requests that
+// fail once processed. Faults are not part of the design and last until
+// cleared with an empty fault.
+type Fault struct {
+	Down      bool `json:"down,omitempty"`
+	Latency   int  `json:"latency,omitempty"`
+	ErrorRate int  `json:"errorRate,omitempty"`
+}
+
+func (f Fault) Validate() error {
+	if f.Latency < 0 {
+		return fmt.Errorf("%w: latency cannot be negative", ErrInvalidFault)
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 100 {
+		return fmt.Errorf("%w: error rate must be between 0 and 100", ErrInvalidFault)
+	}
+	return nil
+}
+
+// Faulty is implemented by nodes that faults can be injected into.
+type Faulty interface {
+	GetFault() Fault
+	SetFault(Fault)
+}
+
+// SetFault injects the fault into a node, taking effect immediately even in
+// a running simulation.
+func (s *System) SetFault(nodeID string, fault Fault) error {
+	err := fault.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	node, ok := s.nodeStore[nodeID]
+	s.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNodeNotFound, nodeID)
+	}
+
+	faulty, ok := node.(Faulty)
+	if !ok {
+		return fmt.Errorf("%w: faults cannot be injected into a %s", ErrInvalidFault, node.GetType())
+	}
+	faulty.SetFault(fault)
+
+	log.Printf("system %s node %s fault set to %+v", s.ID, nodeID, fault)
+	s.publish(NewFaultMessage(nodeID, fault))
+	return nil
+}
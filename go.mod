@@ -3,9 +3,11 @@ module systemdesigner
 go 1.18
 
 require (
-	github.com/google/uuid v1.2.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
-	github.com/lithammer/shortuuid/v3 v3.0.7 // indirect
-	github.com/rs/cors v1.8.3 // indirect
+	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.0
+	github.com/lithammer/shortuuid/v3 v3.0.7
+	github.com/rs/cors v1.8.3
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/google/uuid v1.2.0 // indirect
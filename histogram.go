@@ -0,0 +1,172 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// HistogramGrowth is the ratio between the bounds of consecutive histogram
+// buckets, which bounds the error of a percentile to about 5%.
+const HistogramGrowth = 1.05
+
+// Histogram counts latencies in milliseconds into exponentially growing
+// buckets, so that percentiles can be read off cheaply however many values
+// were recorded. It is safe for concurrent use.
+type Histogram struct {
+	mutex  sync.Mutex
+	counts []int64
+	count  int64
+	sum    int64
+	max    int64
+}
+
+// LatencySummary describes a latency distribution in milliseconds.
+type LatencySummary struct {
+	Mean int `json:"mean"`
+	P50  int `json:"p50"`
+	P90  int `json:"p90"`
+	P99  int `json:"p99"`
+	Max  int `json:"max"`
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func (h *Histogram) Record(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	bucket := histogramBucket(value)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if bucket >= len(h.counts) {
+		counts := make([]int64, bucket+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[bucket]++
+	h.count++
+	h.sum += value
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// Merge adds the values recorded by other.
+func (h *Histogram) Merge(other *Histogram) {
+	other.mutex.Lock()
+	counts := append([]int64{}, other.counts...)
+	count, sum, max := other.count, other.sum, other.max
+	other.mutex.Unlock()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(counts) > len(h.counts) {
+		grown := make([]int64, len(counts))
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	for bucket, n := range counts {
+		h.counts[bucket] += n
+	}
+	h.count += count
+	h.sum += sum
+	if max > h.max {
+		h.max = max
+	}
+}
+
+func (h *Histogram) Count() int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.count
+}
+
+// Cumulative returns how many values were at most each of the ascending
+// bounds, to within a bucket, along with the count and sum of every value.
+func (h *Histogram) Cumulative(bounds []int64) ([]int64, int64, int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	below := make([]int64, len(bounds))
+	var count int64
+	bucket := 0
+	for i, bound := range bounds {
+		for bucket < len(h.counts) && histogramBound(bucket) <= bound {
+			count += h.counts[bucket]
+			bucket++
+		}
+		below[i] = count
+	}
+	return below, h.count, h.sum
+}
+
+// Percentile returns the value below which p percent of the values fall,
+// to within a bucket.
+func (h *Histogram) Percentile(p float64) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.percentile(p)
+}
+
+// percentile reads the histogram. Callers must hold the mutex.
+func (h *Histogram) percentile(p float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for bucket, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			value := histogramBound(bucket)
+			if value > h.max {
+				value = h.max
+			}
+			return value
+		}
+	}
+	return h.max
+}
+
+func (h *Histogram) Summary() LatencySummary {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	summary := LatencySummary{
+		P50: int(h.percentile(50)),
+		P90: int(h.percentile(90)),
+		P99: int(h.percentile(99)),
+		Max: int(h.max),
+	}
+	if h.count > 0 {
+		summary.Mean = int(h.sum / h.count)
+	}
+	return summary
+}
+
+// histogramBucket returns the bucket a value falls into. Bucket i holds the
+// values up to histogramBound(i), and bucket 0 only zero.
+func histogramBucket(value int64) int {
+	if value == 0 {
+		return 0
+	}
+	return 1 + int(math.Ceil(math.Log(float64(value))/math.Log(HistogramGrowth)-1e-9))
+}
+
+func histogramBound(bucket int) int64 {
+	if bucket == 0 {
+		return 0
+	}
+	return int64(math.Floor(math.Pow(HistogramGrowth, float64(bucket-1)) + 1e-9))
+}
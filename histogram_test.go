@@ -0,0 +1,180 @@
+package main
+
+import "testing"
+
+func TestHistogramBuckets(t *testing.T) {
+	if bucket := histogramBucket(0); bucket != 0 || histogramBound(0) != 0 {
+		t.Fatalf("zero went into bucket %d with bound %d", bucket, histogramBound(0))
+	}
+
+	// Every value is at most the bound of its bucket and more than the bound
+	// of the bucket before
+	for value := int64(1); value <= 100000; value++ {
+		bucket := histogramBucket(value)
+		if bound := histogramBound(bucket); bound < value {
+			t.Fatalf("%d went into bucket %d, bounded by %d", value, bucket, bound)
+		}
+		if bound := histogramBound(bucket - 1); bound >= value {
+			t.Fatalf("%d went into bucket %d, but bucket %d is bounded by %d", value, bucket, bucket-1, bound)
+		}
+	}
+
+	// Bucket i is bounded by 1.05^(i-1), rounded down
+	tests := []struct {
+		value  int64
+		bucket int
+		bound  int64
+	}{
+		{1, 1, 1},
+		{2, 16, 2},
+		{10, 49, 10},
+		{11, 51, 11},
+		{100, 96, 103},
+	}
+	for _, test := range tests {
+		bucket := histogramBucket(test.value)
+		if bucket != test.bucket || histogramBound(bucket) != test.bound {
+			t.Errorf("%d went into bucket %d bounded by %d, expected bucket %d bounded by %d", test.value, bucket, histogramBound(bucket), test.bucket, test.bound)
+		}
+	}
+
+	// A value on a bound stays in that bound's bucket, even where rounding
+	// puts its logarithm a hair over a whole number
+	for bucket := 1; bucket <= 250; bucket++ {
+		bound := histogramBound(bucket)
+		if histogramBound(histogramBucket(bound)) != bound {
+			t.Errorf("bound %d of bucket %d went into bucket %d", bound, bucket, histogramBucket(bound))
+		}
+	}
+}
+
+// checkPercentile fails unless got is the true percentile or at most one
+// bucket above it.
+func checkPercentile(t *testing.T, name string, got int64, expected int64) {
+	t.Helper()
+
+	if got < expected || float64(got) > float64(expected)*HistogramGrowth+1 {
+		t.Errorf("%s was %d, expected %d to within a bucket", name, got, expected)
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	uniform := NewHistogram()
+	for value := int64(1); value <= 1000; value++ {
+		uniform.Record(value)
+	}
+	checkPercentile(t, "p50 of 1 to 1000", uniform.Percentile(50), 500)
+	checkPercentile(t, "p99 of 1 to 1000", uniform.Percentile(99), 990)
+	summary := uniform.Summary()
+	if summary.Mean != 500 || summary.Max != 1000 {
+		t.Errorf("got summary %+v of 1 to 1000", summary)
+	}
+
+	// Nine fast requests in ten and one slow one
+	bimodal := NewHistogram()
+	for i := 0; i < 1000; i++ {
+		if i%10 == 0 {
+			bimodal.Record(2000)
+		} else {
+			bimodal.Record(20)
+		}
+	}
+	checkPercentile(t, "p50 of a bimodal distribution", bimodal.Percentile(50), 20)
+	checkPercentile(t, "p90 of a bimodal distribution", bimodal.Percentile(90), 20)
+	if p99 := bimodal.Percentile(99); p99 != 2000 {
+		t.Errorf("p99 of a bimodal distribution was %d, expected the maximum of 2000", p99)
+	}
+
+	// A bucket's bound is never reported above the largest value recorded
+	constant := NewHistogram()
+	for i := 0; i < 100; i++ {
+		constant.Record(333)
+	}
+	summary = constant.Summary()
+	if summary != (LatencySummary{Mean: 333, P50: 333, P90: 333, P99: 333, Max: 333}) {
+		t.Errorf("got summary %+v of a constant 333", summary)
+	}
+
+	empty := NewHistogram()
+	if summary := empty.Summary(); summary != (LatencySummary{}) {
+		t.Errorf("got summary %+v of no values", summary)
+	}
+
+	negative := NewHistogram()
+	negative.Record(-5)
+	if summary := negative.Summary(); summary != (LatencySummary{}) {
+		t.Errorf("got summary %+v of a negative value", summary)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	whole := NewHistogram()
+	low := NewHistogram()
+	high := NewHistogram()
+	for value := int64(0); value <= 5000; value += 7 {
+		whole.Record(value)
+		if value < 100 {
+			low.Record(value)
+		} else {
+			high.Record(value)
+		}
+	}
+
+	// Merging a histogram with more buckets grows the one merged into
+	merged := NewHistogram()
+	merged.Merge(low)
+	merged.Merge(high)
+	merged.Merge(NewHistogram())
+
+	if merged.Count() != whole.Count() {
+		t.Errorf("merged %d values, expected %d", merged.Count(), whole.Count())
+	}
+	if merged.Summary() != whole.Summary() {
+		t.Errorf("got merged summary %+v, expected %+v", merged.Summary(), whole.Summary())
+	}
+	if low.Count()+high.Count() != whole.Count() || low.Summary().Max != 98 {
+		t.Errorf("merging changed the histograms merged from")
+	}
+}
+
+func TestHistogramCumulative(t *testing.T) {
+	histogram := NewHistogram()
+	for value := int64(0); value <= 1000; value++ {
+		histogram.Record(value)
+	}
+
+	bounds := []int64{0, 1, 10, 100, 1000, 5000}
+	below, count, sum := histogram.Cumulative(bounds)
+	if count != 1001 || sum != 500500 {
+		t.Errorf("got count %d and sum %d, expected 1001 and 500500", count, sum)
+	}
+	for i, bound := range bounds {
+		// A bucket straddling the bound is left out
+		atMost := bound + 1
+		if atMost > 1001 {
+			atMost = 1001
+		}
+		atLeast := int64(float64(bound)/HistogramGrowth) + 1
+		if atLeast > 1001 {
+			atLeast = 1001
+		}
+		if below[i] > atMost || below[i] < atLeast {
+			t.Errorf("%d values were at most %d, expected between %d and %d", below[i], bound, atLeast, atMost)
+		}
+	}
+	if below[0] != 1 || below[1] != 2 || below[2] != 11 || below[len(below)-1] != 1001 {
+		t.Errorf("got cumulative counts %v", below)
+	}
+
+	empty := NewHistogram()
+	below, count, sum = empty.Cumulative(bounds)
+	for _, n := range below {
+		if n != 0 {
+			t.Errorf("got cumulative counts %v of no values", below)
+			break
+		}
+	}
+	if count != 0 || sum != 0 {
+		t.Errorf("got count %d and sum %d of no values", count, sum)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+)
+
+// MaxHistory is how many edits a system remembers for undo.
+const MaxHistory = 100
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+const (
+	EditAction string = "edit"
+	UndoAction string = "undo"
+	RedoAction string = "redo"
+)
+
+// Command is one recorded design edit. It keeps the design from before and
+// after the edit, so that undoing and redoing it restore one or the other.
+type Command struct {
+	Name   string       `json:"name"`
+	Before SystemRecord `json:"before"`
+	After  SystemRecord `json:"after"`
+}
+
+// History holds the edits that can be undone, most recent last, and those
+// undone since the last edit that can be redone.
+type History struct {
+	done   []Command
+	undone []Command
+}
+
+// HistoryRecord is the persisted form of a history, so that edits can still
+// be undone after the system is evicted or the server restarts.
+type HistoryRecord struct {
+	Done   []Command `json:"done"`
+	Undone []Command `json:"undone"`
+}
+
+// NewHistoryFromRecord restores a persisted history, keeping at most
+// MaxHistory edits to undo.
+func NewHistoryFromRecord(record *HistoryRecord) History {
+	if record == nil {
+		return History{}
+	}
+
+	done := record.Done
+	if len(done) > MaxHistory {
+		done = done[len(done)-MaxHistory:]
+	}
+	return History{
+		done:   done,
+		undone: record.Undone,
+	}
+}
+
+// Record returns the history for storage, or nil if it is empty.
+func (h *History) Record() *HistoryRecord {
+	if len(h.done) == 0 && len(h.undone) == 0 {
+		return nil
+	}
+
+	return &HistoryRecord{
+		Done:   append([]Command{}, h.done...),
+		Undone: append([]Command{}, h.undone...),
+	}
+}
+
+func (h *History) Push(command Command) {
+	h.done = append(h.done, command)
+	if len(h.done) > MaxHistory {
+		h.done = h.done[len(h.done)-MaxHistory:]
+	}
+	h.undone = nil
+}
+
+func (h *History) Undo() (Command, error) {
+	if len(h.done) == 0 {
+		return Command{}, ErrNothingToUndo
+	}
+
+	command := h.done[len(h.done)-1]
+	h.done = h.done[:len(h.done)-1]
+	h.undone = append(h.undone, command)
+	return command, nil
+}
+
+func (h *History) Redo() (Command, error) {
+	if len(h.undone) == 0 {
+		return Command{}, ErrNothingToRedo
+	}
+
+	command := h.undone[len(h.undone)-1]
+	h.undone = h.undone[:len(h.undone)-1]
+	h.done = append(h.done, command)
+	return command, nil
+}
+
+// HistoryStatus names the commands that can be undone and redone, next
+// one last. Action and Command describe the change that produced it.
+type HistoryStatus struct {
+	Action  string   `json:"action,omitempty"`
+	Command string   `json:"command,omitempty"`
+	Undo    []string `json:"undo"`
+	Redo    []string `json:"redo"`
+}
+
+func (h *History) Status() HistoryStatus {
+	status := HistoryStatus{
+		Undo: []string{},
+		Redo: []string{},
+	}
+	for _, command := range h.done {
+		status.Undo = append(status.Undo, command.Name)
+	}
+	for _, command := range h.undone {
+		status.Redo = append(status.Redo, command.Name)
+	}
+	return status
+}
+
+func (s *System) History() HistoryStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.history.Status()
+}
+
+// ClearHistory forgets every edit, such as those made while building a
+// system from a template.
+func (s *System) ClearHistory() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.history = History{}
+}
+
+// Undo reverts the most recent edit. The system must not be running or
+// paused, as its nodes may be replaced.
+func (s *System) Undo() (HistoryStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.expectStopped()
+	if err != nil {
+		return HistoryStatus{}, err
+	}
+	command, err := s.history.Undo()
+	if err != nil {
+		return HistoryStatus{}, err
+	}
+	log.Printf("system %s undoing %s", s.ID, command.Name)
+	s.restore(command.Before)
+
+	return s.changed(UndoAction, command.Name), nil
+}
+
+// Redo applies the most recently undone edit again. Like Undo, it needs the
+// system to be stopped.
+func (s *System) Redo() (HistoryStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.expectStopped()
+	if err != nil {
+		return HistoryStatus{}, err
+	}
+	command, err := s.history.Redo()
+	if err != nil {
+		return HistoryStatus{}, err
+	}
+	log.Printf("system %s redoing %s", s.ID, command.Name)
+	s.restore(command.After)
+
+	return s.changed(RedoAction, command.Name), nil
+}
+
+// commit records an edit made since before was taken, unless it changed
+// nothing. Callers must hold the mutex.
+func (s *System) commit(name string, before SystemRecord) {
+	after := s.record()
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+
+	s.history.Push(Command{
+		Name:   name,
+		Before: before,
+		After:  after,
+	})
+	s.changed(EditAction, name)
+}
+
+// changed marks the design as updated and tells connected clients about
+// the change. Callers must hold the mutex.
+func (s *System) changed(action string, name string) HistoryStatus {
+	s.metadata.UpdatedAt = time.Now().UTC()
+
+	status := s.history.Status()
+	status.Action = action
+	status.Command = name
+
+	s.publish(NewHistoryMessage(status))
+	return status
+}
+
+// expectStopped fails unless the system is between runs. Callers must hold
+// the mutex.
+func (s *System) expectStopped() error {
+	if s.state == RunningState || s.state == PausedState {
+		return fmt.Errorf("%w: system %s is %s, stop it first", ErrInvalidState, s.ID, s.state)
+	}
+	return nil
+}
+
+// restore makes the design match the record. Nodes whose type and config
+// are unchanged are kept, others are recreated, so the system must not be
+// running. Callers must hold the mutex.
+func (s *System) restore(record SystemRecord) {
+	s.workload = record.Workload
+	s.seed = record.Seed
+
+	kept := map[string]bool{}
+	for _, nodeRecord := range record.Nodes {
+		kept[nodeRecord.ID] = true
+
+		node, ok := s.nodeStore[nodeRecord.ID]
+		if !ok || node.GetType() != nodeRecord.Type || node.GetConfig() != nodeRecord.Config {
+			node = s.addNode(nodeRecord.ID, nodeRecord.Type)
+			err := node.SetConfig(nodeRecord.Config)
+			if err != nil {
+				log.Printf("system %s restoring node %s: %v", s.ID, nodeRecord.ID, err)
+			}
+		}
+		node.SetPosition(nodeRecord.Position)
+	}
+	for nodeID := range s.nodeStore {
+		if !kept[nodeID] {
+			delete(s.nodeStore, nodeID)
+			s.hub.Forget(nodeID)
+		}
+	}
+
+	s.edgeStore = map[string]Edge{}
+	for _, edgeRecord := range record.Edges {
+		s.edgeStore[edgeRecord.ID] = Edge{
+			ID:       edgeRecord.ID,
+			SourceID: edgeRecord.Source,
+			TargetID: edgeRecord.Target,
+		}
+	}
+}
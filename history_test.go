@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryOrder(t *testing.T) {
+	var history History
+	for _, name := range []string{"a", "b", "c"} {
+		history.Push(Command{Name: name})
+	}
+
+	for _, want := range []string{"c", "b"} {
+		command, err := history.Undo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if command.Name != want {
+			t.Errorf("undid %s, expected %s", command.Name, want)
+		}
+	}
+	command, err := history.Redo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command.Name != "b" {
+		t.Errorf("redid %s, expected b", command.Name)
+	}
+
+	status := history.Status()
+	if !reflect.DeepEqual(status.Undo, []string{"a", "b"}) || !reflect.DeepEqual(status.Redo, []string{"c"}) {
+		t.Errorf("status is %+v", status)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	var history History
+	for i := 0; i < MaxHistory+5; i++ {
+		history.Push(Command{Name: fmt.Sprint(i)})
+	}
+
+	undo := history.Status().Undo
+	if len(undo) != MaxHistory || undo[0] != "5" {
+		t.Fatalf("kept %d edits starting with %s", len(undo), undo[0])
+	}
+	for i := 0; i < MaxHistory; i++ {
+		_, err := history.Undo()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := history.Undo()
+	if !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("undoing past the limit returned %v", err)
+	}
+}
+
+// TestEditClearsRedo forgets undone edits once the design is edited again.
+func TestEditClearsRedo(t *testing.T) {
+	system := NewSystem()
+	defer system.Close()
+
+	first, err := system.AddNode(ServerType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = system.AddNode(DatabaseType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := system.Undo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Redo) != 1 {
+		t.Fatalf("redo after undoing is %v", status.Redo)
+	}
+
+	err = system.MoveNode(first.GetID(), Position{X: 1, Y: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = system.History()
+	if len(status.Redo) != 0 {
+		t.Errorf("redo after a new edit is %v", status.Redo)
+	}
+	if !reflect.DeepEqual(status.Undo, []string{"add " + ServerType, "move " + ServerType}) {
+		t.Errorf("undo after a new edit is %v", status.Undo)
+	}
+	_, err = system.Redo()
+	if !errors.Is(err, ErrNothingToRedo) {
+		t.Errorf("redoing after a new edit returned %v", err)
+	}
+}
+
+// TestHistorySurvivesReload undoes an edit made before the system was
+// loaded again from storage.
+func TestHistorySurvivesReload(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSystemStore(storage, Limits{}, nil)
+
+	system := NewSystem()
+	err = store.Add(system)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = system.AddNode(ServerType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Save(system)
+	if err != nil {
+		t.Fatal(err)
+	}
+	system.Close()
+
+	reloaded, err := NewSystemStore(storage, Limits{}, nil).Get(system.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	status, err := reloaded.Undo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Record().Nodes) != 0 {
+		t.Errorf("undo left %d nodes", len(reloaded.Record().Nodes))
+	}
+	if len(status.Redo) != 1 || status.Redo[0] != "add "+ServerType {
+		t.Errorf("redo after reload is %v", status.Redo)
+	}
+}
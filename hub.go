@@ -0,0 +1,258 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+const (
+	// SubscriberBuffer is how many messages a slow subscriber can fall behind
+	// before its oldest ones are dropped.
+	SubscriberBuffer = 256
+	// ReplayBuffer is how many recent messages are kept for subscribers
+	// resuming after a dropped connection.
+	ReplayBuffer = 1000
+)
+
+// Hub broadcasts a system's messages to every subscriber. Publishing never
+// blocks: a subscriber whose buffer is full loses its oldest message instead.
+// The hub remembers the latest status and history, and the metrics and fault
+// of every node, so that late joiners start from the current picture. Every
+// published message is numbered, and the most recent are kept so that a
+// subscriber can pick up where it left off. It is safe for concurrent use.
+type Hub struct {
+	mutex       sync.Mutex
+	subscribers map[*Subscription]bool
+	latest      map[string]Message
+	order       []string
+	recent      []Message
+	seq         int64
+	closed      bool
+}
+
+// Subscription receives the messages published after it was taken, preceded
+// by the hub's latest ones. Its channel is closed once the hub is.
+type Subscription struct {
+	messages chan Message
+	dropped  int
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: map[*Subscription]bool{},
+		latest:      map[string]Message{},
+	}
+}
+
+func (sub *Subscription) Messages() <-chan Message {
+	return sub.messages
+}
+
+// Publish sends the message to every subscriber.
+func (h *Hub) Publish(msg Message) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.seq++
+	msg.Seq = h.seq
+	h.remember(msg)
+
+	h.recent = append(h.recent, msg)
+	if len(h.recent) > ReplayBuffer {
+		h.recent = h.recent[len(h.recent)-ReplayBuffer:]
+	}
+
+	for sub := range h.subscribers {
+		sub.send(msg)
+	}
+}
+
+// Seed remembers a message for late joiners unless a newer one of the same
+// kind has already been published.
+func (h *Hub) Seed(msg Message) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key, ok := messageKey(msg)
+	if !ok {
+		return
+	}
+	if _, exists := h.latest[key]; !exists {
+		h.remember(msg)
+	}
+}
+
+// Subscribe starts from the latest messages, numbered as of now so that a
+// later resume carries on from here.
+func (h *Hub) Subscribe() *Subscription {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	sub := h.subscribe(SubscriberBuffer)
+	for _, key := range h.order {
+		msg := h.latest[key]
+		msg.Seq = h.seq
+		sub.send(msg)
+	}
+	return sub
+}
+
+// SubscribeAfter replays the messages published after seq and continues
+// with new ones. It fails if some of those messages are no longer kept.
+func (h *Hub) SubscribeAfter(seq int64) (*Subscription, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	first := h.seq + 1
+	if len(h.recent) > 0 {
+		first = h.recent[0].Seq
+	}
+	if seq < first-1 || seq > h.seq {
+		return nil, false
+	}
+
+	missed := h.recent[len(h.recent)-int(h.seq-seq):]
+	size := SubscriberBuffer
+	if len(missed) > size {
+		size = len(missed)
+	}
+
+	sub := h.subscribe(size)
+	for _, msg := range missed {
+		sub.send(msg)
+	}
+	return sub, true
+}
+
+// Listen receives only the messages published from now on, with room to
+// fall size messages behind.
+func (h *Hub) Listen(size int) *Subscription {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.subscribe(size)
+}
+
+// subscribe adds a subscriber, or returns an ended subscription if the hub
+// is closed. Callers must hold the mutex.
+func (h *Hub) subscribe(size int) *Subscription {
+	sub := &Subscription{
+		messages: make(chan Message, size),
+	}
+	if h.closed {
+		close(sub.messages)
+		return sub
+	}
+
+	h.subscribers[sub] = true
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.subscribers[sub] {
+		return
+	}
+	delete(h.subscribers, sub)
+	close(sub.messages)
+
+	if sub.dropped > 0 {
+		log.Printf("subscriber fell behind and dropped %d messages", sub.dropped)
+	}
+}
+
+// Subscribers counts the subscriptions still open, including those taken
+// with Listen.
+func (h *Hub) Subscribers() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.subscribers)
+}
+
+// Forget drops the latest metrics and fault of a node, such as one that was
+// deleted.
+func (h *Hub) Forget(nodeID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	forgotten := map[string]bool{
+		MetricsMessage + "/" + nodeID: true,
+		FaultMessage + "/" + nodeID:   true,
+	}
+	order := []string{}
+	for _, key := range h.order {
+		if forgotten[key] {
+			delete(h.latest, key)
+			continue
+		}
+		order = append(order, key)
+	}
+	h.order = order
+}
+
+// Close ends every subscription. Later publishes are ignored.
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for sub := range h.subscribers {
+		close(sub.messages)
+	}
+	h.subscribers = map[*Subscription]bool{}
+}
+
+// remember keeps the message if late joiners need it. Callers must hold the
+// mutex.
+func (h *Hub) remember(msg Message) {
+	key, ok := messageKey(msg)
+	if !ok {
+		return
+	}
+	if _, exists := h.latest[key]; !exists {
+		h.order = append(h.order, key)
+	}
+	h.latest[key] = msg
+}
+
+// messageKey names the state a message describes. Events happen once and
+// are not kept.
+func messageKey(msg Message) (string, bool) {
+	switch msg.Type {
+	case StatusMessage, HistoryMessage:
+		return msg.Type, true
+	case MetricsMessage, FaultMessage:
+		return msg.Type + "/" + msg.NodeID, true
+	}
+	return "", false
+}
+
+// send queues the message, dropping the oldest queued one if the buffer is
+// full. Only the hub sends, under its mutex, so the retry always has room.
+func (sub *Subscription) send(msg Message) {
+	select {
+	case sub.messages <- msg:
+		return
+	default:
+	}
+
+	select {
+	case <-sub.messages:
+		sub.dropped++
+	default:
+	}
+	select {
+	case sub.messages <- msg:
+	default:
+		sub.dropped++
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+)
+
+// received takes the messages already waiting on the subscription.
+func received(sub *Subscription) []Message {
+	messages := []Message{}
+	for {
+		select {
+		case msg := <-sub.Messages():
+			messages = append(messages, msg)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestSubscribeAfter(t *testing.T) {
+	hub := NewHub()
+	defer hub.Close()
+	for i := 0; i < 10; i++ {
+		hub.Publish(Message{Type: EventMessage})
+	}
+
+	sub, ok := hub.SubscribeAfter(7)
+	if !ok {
+		t.Fatal("could not resume after message 7")
+	}
+	hub.Publish(Message{Type: EventMessage})
+	messages := received(sub)
+	if len(messages) != 4 {
+		t.Fatalf("resuming after message 7 of 11 received %d messages", len(messages))
+	}
+	for i, msg := range messages {
+		if msg.Seq != int64(8+i) {
+			t.Errorf("message %d is numbered %d, expected %d", i, msg.Seq, 8+i)
+		}
+	}
+
+	_, ok = hub.SubscribeAfter(12)
+	if ok {
+		t.Error("resumed after a message not yet published")
+	}
+}
+
+// TestSubscribeAfterGap refuses to resume once the missed messages are more
+// than the hub keeps.
+func TestSubscribeAfterGap(t *testing.T) {
+	hub := NewHub()
+	defer hub.Close()
+	for i := 0; i < ReplayBuffer+10; i++ {
+		hub.Publish(Message{Type: EventMessage})
+	}
+
+	_, ok := hub.SubscribeAfter(5)
+	if ok {
+		t.Error("resumed after a message no longer kept")
+	}
+
+	// The oldest message kept is number 11, so resuming after 10 just fits
+	sub, ok := hub.SubscribeAfter(10)
+	if !ok {
+		t.Fatal("could not resume after the oldest message kept")
+	}
+	messages := received(sub)
+	if len(messages) != ReplayBuffer || messages[0].Seq != 11 {
+		t.Errorf("resuming after message 10 received %d messages starting at %d", len(messages), messages[0].Seq)
+	}
+}
+
+// TestSlowSubscriberDropsOldest keeps the newest messages for a subscriber
+// that fell behind, without holding up the others or the publisher.
+func TestSlowSubscriberDropsOldest(t *testing.T) {
+	hub := NewHub()
+	defer hub.Close()
+	slow := hub.Listen(4)
+	fast := hub.Listen(20)
+
+	for i := 0; i < 10; i++ {
+		hub.Publish(Message{Type: EventMessage})
+	}
+
+	messages := received(slow)
+	if len(messages) != 4 {
+		t.Fatalf("the slow subscriber received %d messages, expected 4", len(messages))
+	}
+	for i, msg := range messages {
+		if msg.Seq != int64(7+i) {
+			t.Errorf("the slow subscriber's message %d is numbered %d, expected %d", i, msg.Seq, 7+i)
+		}
+	}
+	if slow.dropped != 6 {
+		t.Errorf("the slow subscriber dropped %d messages, expected 6", slow.dropped)
+	}
+	if messages := received(fast); len(messages) != 10 {
+		t.Errorf("the fast subscriber received %d messages, expected 10", len(messages))
+	}
+}
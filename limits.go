@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLimitExceeded = errors.New("limit exceeded")
+	ErrTooManyRuns   = errors.New("too many running simulations")
+)
+
+// Limits bound the resources a single system, and all of them together, may
+// hold. A zero limit is no limit.
+type Limits struct {
+	IdleTTL        time.Duration
+	MaxRunDuration time.Duration
+	MaxNodes       int
+	MaxEdges       int
+	MaxRunning     int
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		IdleTTL:        30 * time.Minute,
+		MaxRunDuration: 10 * time.Minute,
+		MaxNodes:       50,
+		MaxEdges:       200,
+		MaxRunning:     10,
+	}
+}
+
+// LimitsFromEnv reads the limits from IDLE_TTL, MAX_RUN_DURATION, MAX_NODES,
+// MAX_EDGES and MAX_RUNNING, keeping the default for any that are unset.
+func LimitsFromEnv() (Limits, error) {
+	limits := DefaultLimits()
+
+	durations := map[string]*time.Duration{
+		"IDLE_TTL":         &limits.IdleTTL,
+		"MAX_RUN_DURATION": &limits.MaxRunDuration,
+	}
+	for name, limit := range durations {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			return Limits{}, fmt.Errorf("%s must be a duration such as 30m, got %q", name, value)
+		}
+		*limit = duration
+	}
+
+	counts := map[string]*int{
+		"MAX_NODES":   &limits.MaxNodes,
+		"MAX_EDGES":   &limits.MaxEdges,
+		"MAX_RUNNING": &limits.MaxRunning,
+	}
+	for name, limit := range counts {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return Limits{}, fmt.Errorf("%s must be a whole number, got %q", name, value)
+		}
+		*limit = count
+	}
+
+	return limits, nil
+}
+
+// CheckDesign returns ErrLimitExceeded if the design has more nodes or edges
+// than allowed.
+func (l Limits) CheckDesign(nodes int, edges int) error {
+	if l.MaxNodes > 0 && nodes > l.MaxNodes {
+		return fmt.Errorf("%w: a system can have at most %d nodes", ErrLimitExceeded, l.MaxNodes)
+	}
+	if l.MaxEdges > 0 && edges > l.MaxEdges {
+		return fmt.Errorf("%w: a system can have at most %d edges", ErrLimitExceeded, l.MaxEdges)
+	}
+	return nil
+}
+
+// RunLimiter counts the simulations running across all systems. It is safe
+// for concurrent use, and a nil limiter allows any number of runs.
+type RunLimiter struct {
+	mutex   sync.Mutex
+	running int
+	max     int
+}
+
+func NewRunLimiter(max int) *RunLimiter {
+	return &RunLimiter{max: max}
+}
+
+// Acquire takes a run slot, or returns ErrTooManyRuns if none is free.
+func (rl *RunLimiter) Acquire() error {
+	if rl == nil {
+		return nil
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.max > 0 && rl.running >= rl.max {
+		return fmt.Errorf("%w: at most %d can run at once, try again later", ErrTooManyRuns, rl.max)
+	}
+	rl.running++
+	return nil
+}
+
+func (rl *RunLimiter) Release() {
+	if rl == nil {
+		return
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.running--
+}
+
+// evictIdle periodically drops systems from memory that have not been used
+// for the idle TTL, are not running and have no one watching them. They stay
+// in storage and are loaded again on their next use.
+func (ss *SystemStore) evictIdle() {
+	interval := ss.limits.IdleTTL / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	} else if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ss.evict(time.Now().Add(-ss.limits.IdleTTL))
+	}
+}
+
+// evict closes and forgets the idle systems last used before cutoff.
+func (ss *SystemStore) evict(cutoff time.Time) {
+	ss.mutex.Lock()
+	evicted := []*System{}
+	for id, system := range ss.systems {
+		if ss.accessed[id].After(cutoff) {
+			continue
+		}
+		state := system.Status().State
+		if state == RunningState || state == PausedState {
+			continue
+		}
+		if system.Watched() {
+			continue
+		}
+
+		delete(ss.systems, id)
+		delete(ss.accessed, id)
+		evicted = append(evicted, system)
+	}
+	ss.mutex.Unlock()
+
+	for _, system := range evicted {
+		system.Close()
+		log.Printf("system %s evicted after being idle for %v", system.ID, ss.limits.IdleTTL)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSaveAfterEviction(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := DefaultLimits()
+	limits.IdleTTL = 0
+	store := NewSystemStore(storage, limits, nil)
+
+	system, err := NewSystemFromTemplate("three-tier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Add(system)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An edit that lands after the system was evicted must not be lost
+	// silently
+	store.evict(time.Now())
+	system.SetSeed(7)
+	err = store.Save(system)
+	if !errors.Is(err, ErrSystemUnloaded) {
+		t.Fatalf("saving an evicted system returned %v", err)
+	}
+
+	reloaded, err := store.Get(system.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == system {
+		t.Fatal("the evicted system was not reloaded")
+	}
+	err = store.Save(reloaded)
+	if err != nil {
+		t.Errorf("saving the reloaded system returned %v", err)
+	}
+}
+
+// TestEvictSkipsWatchedSystems keeps a system in memory while a client is
+// subscribed to it, however long ago it was last used.
+func TestEvictSkipsWatchedSystems(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSystemStore(storage, Limits{}, nil)
+
+	system := NewSystem()
+	err = store.Add(system)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := system.Subscribe()
+	store.evict(time.Now())
+	if _, ok := store.systems[system.ID]; !ok {
+		t.Fatal("a watched system was evicted")
+	}
+
+	system.Unsubscribe(sub)
+	store.evict(time.Now())
+	if _, ok := store.systems[system.ID]; ok {
+		t.Error("an unwatched idle system was kept")
+	}
+}
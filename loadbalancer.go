@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"github.com/lithammer/shortuuid/v3"
+	"fmt"
 	"log"
 	"sync"
 	"sync/atomic"
@@ -14,27 +14,41 @@ type LoadBalancer struct {
 	Type     string
 	Position Position
 
-	InRequests  chan Request
-	InResponses chan Response
-	Targets     []Target
+	InRequests  chan Request  `json:"-"`
+	InResponses chan Response `json:"-"`
+	Targets     []Target      `json:"-"`
+	leastQueued bool
+	outstanding []int64
 
-	messages     chan Message
+	hub          *Hub
+	clock        *Clock
+	tracer       *Tracer
 	numProcessed int64
+	waits        *Histogram
+	services     *Histogram
 }
 
-type Target struct {
-	OutRequests  chan Request
-	OutResponses chan Response
-}
-
-func NewLoadBalancer(messages chan Message) *LoadBalancer {
+func NewLoadBalancer(id string, hub *Hub, clock *Clock) *LoadBalancer {
 	return &LoadBalancer{
-		ID:       shortuuid.New(),
+		ID:       id,
 		Type:     LoadBalancerType,
-		messages: messages,
+		hub:      hub,
+		clock:    clock,
+		waits:    NewHistogram(),
+		services: NewHistogram(),
 	}
 }
 
+// NewQueue returns a load balancer that hands each request to the target
+// with the fewest requests outstanding, the way idle workers pull jobs off a
+// queue.
+func NewQueue(id string, hub *Hub, clock *Clock) *LoadBalancer {
+	queue := NewLoadBalancer(id, hub, clock)
+	queue.Type = QueueType
+	queue.leastQueued = true
+	return queue
+}
+
 func (lb *LoadBalancer) GetID() string {
 	return lb.ID
 }
@@ -51,6 +65,17 @@ func (lb *LoadBalancer) SetPosition(pos Position) {
 	lb.Position = pos
 }
 
+func (lb *LoadBalancer) GetConfig() NodeConfig {
+	return NodeConfig{}
+}
+
+func (lb *LoadBalancer) SetConfig(config NodeConfig) error {
+	if config != (NodeConfig{}) {
+		return fmt.Errorf("%s has no configuration", lb.Type)
+	}
+	return nil
+}
+
 func (lb *LoadBalancer) SetInChannels(inRequests chan Request, inResponses chan Response) {
 	lb.InRequests = inRequests
 	lb.InResponses = inResponses
@@ -61,10 +86,11 @@ func (lb *LoadBalancer) SetOutChannels(outRequests chan Request, outResponses ch
 		OutRequests:  outRequests,
 		OutResponses: outResponses,
 	})
+	lb.outstanding = append(lb.outstanding, 0)
 }
 
 func (lb *LoadBalancer) Run(ctx context.Context, wg *sync.WaitGroup, _ context.CancelFunc) {
-	metricsTicker := time.NewTicker(100 * time.Millisecond)
+	metricsTicker := lb.clock.NewTicker(ctx, MetricsInterval)
 
 	wg.Add(1)
 	go func() {
@@ -77,22 +103,42 @@ func (lb *LoadBalancer) Run(ctx context.Context, wg *sync.WaitGroup, _ context.C
 				log.Printf("%s recieving goroutine cancelled", lb.Type)
 				return
 			case request := <-lb.InRequests:
+				err := lb.clock.Acquire(ctx)
+				if err != nil {
+					log.Printf("%s recieving goroutine cancelled", lb.Type)
+					return
+				}
+
+				dequeuedAt := lb.clock.Now()
+				lb.waits.Record(dequeuedAt.Sub(request.EnqueuedAt).Milliseconds())
+				request = lb.tracer.Start(lb, request, dequeuedAt)
+				if lb.leastQueued {
+					targetNumber = lb.shortestTarget()
+				}
+
+				// The request holds the clock until the target handles it
 				log.Printf("%s forwarding request from client to target #%d", lb.Type, targetNumber)
-				lb.Targets[targetNumber].OutRequests <- request
+				request.EnqueuedAt = lb.clock.Now()
+				select {
+				case lb.Targets[targetNumber].OutRequests <- request:
+				case <-ctx.Done():
+					log.Printf("%s recieving goroutine cancelled", lb.Type)
+					return
+				}
+				atomic.AddInt64(&lb.outstanding[targetNumber], 1)
+				lb.services.Record(lb.clock.Now().Sub(dequeuedAt).Milliseconds())
+				lb.clock.Record(NewEvent(lb, RequestForwardedEvent, request.ID, fmt.Sprintf("%s forwarded request %d to target #%d", lb.Type, request.ID, targetNumber)))
 				targetNumber++
 				if targetNumber == len(lb.Targets) {
 					targetNumber = 0
 				}
 			case _ = <-metricsTicker.C:
-				log.Printf("%s sending metrics: Processed = %d, Queued = %d", lb.Type, int(lb.numProcessed), len(lb.InRequests))
-
-				lb.messages <- Message{
-					NodeID: lb.ID,
-					Metrics: []Metric{
-						NewProcessed(int(lb.numProcessed)),
-						NewQueued(len(lb.InRequests)),
-					},
-				}
+				metrics := lb.GetMetrics()
+				log.Printf("%s sending metrics: Processed = %d, Queued = %d", lb.Type, metrics[0].Value, metrics[1].Value)
+
+				msg := NewMetricsMessage(lb.ID, lb.clock.Elapsed(), metrics)
+				lb.hub.Publish(msg)
+				lb.clock.Release()
 			}
 		}
 	}()
@@ -107,23 +153,67 @@ func (lb *LoadBalancer) Run(ctx context.Context, wg *sync.WaitGroup, _ context.C
 					log.Printf("%s target #%d goroutine cancelled", lb.Type, targetNumber)
 					return
 				case response := <-target.OutResponses:
+					err := lb.clock.Acquire(ctx)
+					if err != nil {
+						log.Printf("%s target #%d goroutine cancelled", lb.Type, targetNumber)
+						return
+					}
+
 					log.Printf("%s forwarding response from target #%d to client", lb.Type, targetNumber)
-					lb.InResponses <- response
+					atomic.AddInt64(&lb.outstanding[targetNumber], -1)
+					lb.tracer.End(lb, response.TraceID, lb.clock.Now(), 0, response.Failed)
+					select {
+					case lb.InResponses <- response:
+					case <-ctx.Done():
+						log.Printf("%s target #%d goroutine cancelled", lb.Type, targetNumber)
+						return
+					}
 					atomic.AddInt64(&lb.numProcessed, 1)
+					lb.clock.Record(NewEvent(lb, ResponseForwardedEvent, response.ID, fmt.Sprintf("%s forwarded response for request %d from target #%d", lb.Type, response.ID, targetNumber)))
 				}
 			}
 		}(i, target)
 	}
 }
 
-func (lb *LoadBalancer) Reset() {
+// shortestTarget returns the target with the fewest requests forwarded but
+// not yet answered, preferring earlier targets on ties.
+func (lb *LoadBalancer) shortestTarget() int {
+	shortest := 0
+	for i := range lb.Targets {
+		if atomic.LoadInt64(&lb.outstanding[i]) < atomic.LoadInt64(&lb.outstanding[shortest]) {
+			shortest = i
+		}
+	}
+	return shortest
+}
+
+func (lb *LoadBalancer) Reset(run RunConfig) {
 	lb.Targets = []Target{}
-	lb.numProcessed = 0
+	lb.outstanding = []int64{}
+	lb.tracer = run.Tracer
+	atomic.StoreInt64(&lb.numProcessed, 0)
+	lb.waits = NewHistogram()
+	lb.services = NewHistogram()
+}
+
+func (lb *LoadBalancer) Summary(elapsed time.Duration) NodeSummary {
+	summary := NewNodeSummary(lb, elapsed, atomic.LoadInt64(&lb.numProcessed), 0, lb.services)
+	wait := lb.waits.Summary()
+	summary.Wait = &wait
+	return summary
+}
+
+// Waits returns how long requests queued for the load balancer in the
+// current run.
+func (lb *LoadBalancer) Waits() *Histogram {
+	return lb.waits
 }
 
 func (lb *LoadBalancer) GetMetrics() []Metric {
-	return []Metric{
-		NewProcessed(0),
-		NewQueued(0),
+	metrics := []Metric{
+		NewProcessed(int(atomic.LoadInt64(&lb.numProcessed))),
+		NewQueued(len(lb.InRequests)),
 	}
+	return append(metrics, NewQueueingMetrics(lb.waits, lb.services)...)
 }
@@ -8,7 +8,22 @@ import (
 )
 
 func main() {
-	router := RegisterRoutes()
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	storage, err := NewFileStorage(dataDir)
+	if err != nil {
+		log.Fatal("NewFileStorage: ", err)
+	}
+
+	limits, err := LimitsFromEnv()
+	if err != nil {
+		log.Fatal("LimitsFromEnv: ", err)
+	}
+
+	router := RegisterRoutes(storage, limits, TraceExporterFromEnv())
 	handler := http.Handler(router)
 
 	if os.Getenv("ENV") != "PROD" {
@@ -23,7 +38,7 @@ func main() {
 	}
 
 	log.Println("starting server")
-	err := http.ListenAndServe(":5000", handler)
+	err = http.ListenAndServe(":5000", handler)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
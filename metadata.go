@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MaxNameLength        = 100
+	MaxDescriptionLength = 2000
+	MaxTags              = 20
+	MaxTagLength         = 50
+
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// Metadata describes a system to the people browsing and sharing it.
+type Metadata struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func NewMetadata() Metadata {
+	now := time.Now().UTC()
+	return Metadata{
+		Tags:      []string{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func (m Metadata) Validate() error {
+	if len([]rune(m.Name)) > MaxNameLength {
+		return fmt.Errorf("name must be at most %d characters", MaxNameLength)
+	}
+	if len([]rune(m.Description)) > MaxDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", MaxDescriptionLength)
+	}
+	if len(m.Tags) > MaxTags {
+		return fmt.Errorf("a system can have at most %d tags", MaxTags)
+	}
+	for _, tag := range m.Tags {
+		if tag == "" || len([]rune(tag)) > MaxTagLength {
+			return fmt.Errorf("tag %q must be between 1 and %d characters", tag, MaxTagLength)
+		}
+	}
+	return nil
+}
+
+// NormaliseTags trims and lowercases tags and drops blanks and duplicates.
+func NormaliseTags(tags []string) []string {
+	normalised := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalised = append(normalised, tag)
+	}
+	return normalised
+}
+
+func (s *System) Metadata() Metadata {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	metadata := s.metadata
+	metadata.Tags = append([]string{}, s.metadata.Tags...)
+	return metadata
+}
+
+// SetMetadata changes the name, description and tags. The timestamps are
+// kept by the system and ignored.
+func (s *System) SetMetadata(metadata Metadata) error {
+	metadata.Tags = NormaliseTags(metadata.Tags)
+	err := metadata.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.metadata.Name = metadata.Name
+	s.metadata.Description = metadata.Description
+	s.metadata.Tags = metadata.Tags
+	s.metadata.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// SystemSummary describes a system in a listing, without its design.
+type SystemSummary struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parentId,omitempty"`
+	Metadata
+	Nodes int    `json:"nodes"`
+	Edges int    `json:"edges"`
+	State string `json:"state"`
+}
+
+// ListOptions filters and pages a listing of systems. Query matches the
+// name and description, and every one of Tags must be present.
+type ListOptions struct {
+	Query    string
+	Tags     []string
+	ParentID string
+	Offset   int
+	Limit    int
+}
+
+type SystemList struct {
+	Systems []SystemSummary `json:"systems"`
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+}
+
+// List returns the stored systems matching the options, most recently
+// updated first.
+func (ss *SystemStore) List(options ListOptions) (SystemList, error) {
+	records, err := ss.storage.List()
+	if err != nil {
+		return SystemList{}, err
+	}
+
+	query := strings.ToLower(options.Query)
+	tags := NormaliseTags(options.Tags)
+
+	summaries := []SystemSummary{}
+	for _, record := range records {
+		if options.ParentID != "" && record.ParentID != options.ParentID {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(record.Metadata.Name), query) &&
+			!strings.Contains(strings.ToLower(record.Metadata.Description), query) {
+			continue
+		}
+		if !hasTags(record.Metadata.Tags, tags) {
+			continue
+		}
+
+		summaries = append(summaries, SystemSummary{
+			ID:       record.ID,
+			ParentID: record.ParentID,
+			Metadata: record.Metadata,
+			Nodes:    len(record.Nodes),
+			Edges:    len(record.Edges),
+			State:    ss.state(record.ID),
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].UpdatedAt.Equal(summaries[j].UpdatedAt) {
+			return summaries[i].ID < summaries[j].ID
+		}
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+
+	list := SystemList{
+		Systems: []SystemSummary{},
+		Total:   len(summaries),
+		Offset:  options.Offset,
+		Limit:   options.Limit,
+	}
+	if options.Offset < len(summaries) {
+		end := options.Offset + options.Limit
+		if end > len(summaries) {
+			end = len(summaries)
+		}
+		list.Systems = summaries[options.Offset:end]
+	}
+
+	return list, nil
+}
+
+// state returns the run state of a system in memory. Systems only in
+// storage are idle.
+func (ss *SystemStore) state(id string) string {
+	ss.mutex.Lock()
+	system, ok := ss.systems[id]
+	ss.mutex.Unlock()
+
+	if !ok {
+		return IdleState
+	}
+	return system.Status().State
+}
+
+func hasTags(tags []string, required []string) bool {
+	for _, tag := range required {
+		found := false
+		for _, candidate := range tags {
+			if candidate == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete stops the system if it is running, drops it from memory and removes
+// it and its snapshots from storage.
+func (ss *SystemStore) Delete(id string) error {
+	ss.mutex.Lock()
+	system, ok := ss.systems[id]
+	delete(ss.systems, id)
+	delete(ss.accessed, id)
+	ss.mutex.Unlock()
+
+	if ok {
+		system.Close()
+	}
+
+	err := ss.storage.Delete(id)
+	if errors.Is(err, ErrSystemNotFound) && ok {
+		// Never saved, which is fine as it was in memory
+		return nil
+	}
+	if err == nil {
+		log.Printf("system %s deleted", id)
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetMetadata(t *testing.T) {
+	system := NewSystem()
+	defer system.Close()
+	created := system.Metadata().CreatedAt
+
+	err := system.SetMetadata(Metadata{
+		Name:      "Shop",
+		Tags:      []string{" Demo", "demo", "", "Caching "},
+		CreatedAt: time.Unix(0, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := system.Metadata()
+	if metadata.Name != "Shop" || !reflect.DeepEqual(metadata.Tags, []string{"demo", "caching"}) {
+		t.Errorf("got %+v", metadata)
+	}
+	if !metadata.CreatedAt.Equal(created) || metadata.UpdatedAt.Before(created) {
+		t.Errorf("timestamps changed to %v and %v", metadata.CreatedAt, metadata.UpdatedAt)
+	}
+
+	err = system.SetMetadata(Metadata{Name: strings.Repeat("x", MaxNameLength+1)})
+	if err == nil {
+		t.Error("accepted a name that is too long")
+	}
+	if system.Metadata().Name != "Shop" {
+		t.Errorf("a rejected change renamed the system to %q", system.Metadata().Name)
+	}
+}
+
+func TestList(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().UTC()
+	for i, record := range []SystemRecord{
+		{ID: "a", Metadata: Metadata{Name: "Shop", Tags: []string{"demo"}}},
+		{ID: "b", Metadata: Metadata{Name: "Blog", Description: "a shop's blog", Tags: []string{"demo", "caching"}}},
+		{ID: "c", ParentID: "a", Metadata: Metadata{Name: "Shop with cache", Tags: []string{"caching"}}},
+	} {
+		record.Metadata.CreatedAt = start
+		record.Metadata.UpdatedAt = start.Add(time.Duration(i) * time.Minute)
+		err = storage.Save(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := NewSystemStore(storage, Limits{}, nil)
+
+	tests := []struct {
+		name    string
+		options ListOptions
+		ids     []string
+		total   int
+	}{
+		{"everything", ListOptions{Limit: 10}, []string{"c", "b", "a"}, 3},
+		{"query", ListOptions{Query: "SHOP", Limit: 10}, []string{"c", "b", "a"}, 3},
+		{"query by name", ListOptions{Query: "cache", Limit: 10}, []string{"c"}, 1},
+		{"tag", ListOptions{Tags: []string{"Demo"}, Limit: 10}, []string{"b", "a"}, 2},
+		{"every tag", ListOptions{Tags: []string{"demo", "caching"}, Limit: 10}, []string{"b"}, 1},
+		{"parent", ListOptions{ParentID: "a", Limit: 10}, []string{"c"}, 1},
+		{"page", ListOptions{Offset: 1, Limit: 1}, []string{"b"}, 3},
+		{"past the end", ListOptions{Offset: 5, Limit: 10}, []string{}, 3},
+	}
+	for _, test := range tests {
+		list, err := store.List(test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, summary := range list.Systems {
+			ids = append(ids, summary.ID)
+		}
+		if !reflect.DeepEqual(ids, test.ids) || list.Total != test.total {
+			t.Errorf("%s: listed %v of %d, expected %v of %d", test.name, ids, list.Total, test.ids, test.total)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSystemStore(storage, Limits{}, nil)
+
+	system := NewSystem()
+	err = store.Add(system)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.SaveSnapshot(system, "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Delete(system.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(system.ID)
+	if !errors.Is(err, ErrSystemNotFound) {
+		t.Errorf("getting a deleted system returned %v", err)
+	}
+	snapshots, err := storage.LoadSnapshots(system.ID)
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("the deleted system left %d snapshots: %v", len(snapshots), err)
+	}
+	list, err := store.List(ListOptions{Limit: 10})
+	if err != nil || list.Total != 0 {
+		t.Errorf("listed %d systems after deleting: %v", list.Total, err)
+	}
+
+	err = store.Delete(system.ID)
+	if !errors.Is(err, ErrSystemNotFound) {
+		t.Errorf("deleting twice returned %v", err)
+	}
+}
@@ -1,10 +1,78 @@
 package main
 
-import "math"
+import (
+	"math"
+	"time"
+)
 
+const MetricsInterval = 100 * time.Millisecond
+
+const (
+	MetricsMessage string = "metrics"
+	StatusMessage  string = "status"
+	EventMessage   string = "event"
+	HistoryMessage string = "history"
+	FaultMessage   string = "fault"
+	AckMessage     string = "ack"
+	ErrorMessage   string = "error"
+)
+
+// Message is sent to websocket clients. Acks and errors answer a command,
+// carrying its ID and type. Seq numbers the messages published by a system,
+// and Time is when in the run metrics were taken, in simulated milliseconds.
 type Message struct {
-	NodeID  string   `json:"nodeId"`
-	Metrics []Metric `json:"metrics"`
+	Seq        int64          `json:"-"`
+	Type       string         `json:"type"`
+	Time       int            `json:"time,omitempty"`
+	ID         string         `json:"id,omitempty"`
+	Command    string         `json:"command,omitempty"`
+	NodeID     string         `json:"nodeId,omitempty"`
+	Metrics    []Metric       `json:"metrics,omitempty"`
+	Status     *SystemStatus  `json:"status,omitempty"`
+	Event      *Event         `json:"event,omitempty"`
+	History    *HistoryStatus `json:"history,omitempty"`
+	Fault      *Fault         `json:"fault,omitempty"`
+	Code       string         `json:"code,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Validation *Validation    `json:"validation,omitempty"`
+}
+
+func NewMetricsMessage(nodeID string, elapsed time.Duration, metrics []Metric) Message {
+	return Message{
+		Type:    MetricsMessage,
+		Time:    int(elapsed.Milliseconds()),
+		NodeID:  nodeID,
+		Metrics: metrics,
+	}
+}
+
+func NewStatusMessage(status SystemStatus) Message {
+	return Message{
+		Type:   StatusMessage,
+		Status: &status,
+	}
+}
+
+func NewEventMessage(event Event) Message {
+	return Message{
+		Type:  EventMessage,
+		Event: &event,
+	}
+}
+
+func NewHistoryMessage(history HistoryStatus) Message {
+	return Message{
+		Type:    HistoryMessage,
+		History: &history,
+	}
+}
+
+func NewFaultMessage(nodeID string, fault Fault) Message {
+	return Message{
+		Type:   FaultMessage,
+		NodeID: nodeID,
+		Fault:  &fault,
+	}
 }
 
 type Metric struct {
@@ -41,6 +109,46 @@ func NewAvgLatency(value int) Metric {
 	}
 }
 
+// NewQueueingMetrics splits the time a node takes over requests into the
+// time they waited in its queue and the time it then spent serving them, on
+// average and at the 99th percentile.
+func NewQueueingMetrics(waits *Histogram, services *Histogram) []Metric {
+	wait := waits.Summary()
+	service := services.Summary()
+	return []Metric{
+		NewWaitTime("Avg. Wait", wait.Mean),
+		NewWaitTime("p99 Wait", wait.P99),
+		NewServiceTime("Avg. Service", service.Mean),
+		NewServiceTime("p99 Service", service.P99),
+	}
+}
+
+func NewWaitTime(name string, value int) Metric {
+	return Metric{
+		Name:     name,
+		Value:    value,
+		Unit:     "ms",
+		Severity: math.Min(1, float64(value)/500.0),
+	}
+}
+
+func NewServiceTime(name string, value int) Metric {
+	return Metric{
+		Name:  name,
+		Value: value,
+		Unit:  "ms",
+	}
+}
+
+func NewErrorRate(value int) Metric {
+	return Metric{
+		Name:     "Errors",
+		Value:    value,
+		Unit:     "%",
+		Severity: math.Min(1, float64(value)/10.0),
+	}
+}
+
 func NewQueued(value int) Metric {
 	severity := math.Min(1, float64(value)/100.0)
 	return Metric{
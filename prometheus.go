@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PrometheusNamespace prefixes every exposed metric.
+	PrometheusNamespace = "systemdesigner"
+	// PrometheusContentType is the content type of the text exposition
+	// format.
+	PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// PrometheusLatencyBuckets are the upper bounds, in milliseconds, of the
+// buckets that node latencies and waits are exposed in.
+var PrometheusLatencyBuckets = []int64{5, 10, 25, 50, 100, 250, 500, 750, 1000, 2500, 5000, 10000}
+
+// prometheusKinds gives the type of each node metric that is exposed.
+// Counts only grow during a run, while Queued and Utilisation go up and
+// down. Averages, percentiles and rates are left out, as Prometheus derives
+// them from the counters and histograms.
+var prometheusKinds = map[string]string{
+	"Processed":   "counter",
+	"Responses":   "counter",
+	"Queued":      "gauge",
+	"Utilisation": "gauge",
+}
+
+// latencyRecorder is implemented by the nodes that measure the latency of
+// the requests they answer.
+type latencyRecorder interface {
+	Latencies() *Histogram
+}
+
+// waitRecorder is implemented by the nodes that measure how long requests
+// queue for them.
+type waitRecorder interface {
+	Waits() *Histogram
+}
+
+// Exposition gathers metrics into families, so that the samples of each are
+// written together as the text format requires.
+type Exposition struct {
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	help    string
+	kind    string
+	samples []string
+}
+
+func NewExposition() *Exposition {
+	return &Exposition{
+		families: map[string]*metricFamily{},
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Add records a sample, given its labels as name and value pairs. The family
+// is declared by its first sample, and the _bucket, _sum and _count samples
+// of a histogram all belong to one.
+func (e *Exposition) Add(name string, kind string, help string, labels []string, value float64) {
+	key := name
+	if kind == "histogram" {
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			key = strings.TrimSuffix(key, suffix)
+		}
+	}
+
+	family, ok := e.families[key]
+	if !ok {
+		family = &metricFamily{help: help, kind: kind}
+		e.families[key] = family
+	}
+
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	sample := name
+	if len(pairs) > 0 {
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	family.samples = append(family.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// WriteTo writes every family, ordered by name.
+func (e *Exposition) WriteTo(writer io.Writer) (int64, error) {
+	names := []string{}
+	for name := range e.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var written int64
+	for _, name := range names {
+		family := e.families[name]
+		text := fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		text += strings.Join(family.samples, "\n") + "\n"
+		n, err := io.WriteString(writer, text)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Active returns the systems in memory that are running or paused.
+func (ss *SystemStore) Active() []*System {
+	ss.mutex.Lock()
+	systems := []*System{}
+	for _, system := range ss.systems {
+		systems = append(systems, system)
+	}
+	ss.mutex.Unlock()
+
+	active := []*System{}
+	for _, system := range systems {
+		state := system.Status().State
+		if state == RunningState || state == PausedState {
+			active = append(active, system)
+		}
+	}
+	return active
+}
+
+// Expose adds the metrics of every node of the system, labelled with the
+// system, node ID and node type. Counters start again from zero with every
+// run.
+func (s *System) Expose(exposition *Exposition) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	elapsed := s.clock.Elapsed()
+	for _, node := range s.nodeStore {
+		labels := []string{"system", s.ID, "node", node.GetID(), "type", node.GetType()}
+
+		for _, metric := range node.GetMetrics() {
+			kind, ok := prometheusKinds[metric.Name]
+			if !ok {
+				continue
+			}
+			help := fmt.Sprintf("The %s node metric.", metric.Name)
+			exposition.Add(prometheusName(metric, kind), kind, help, labels, float64(metric.Value))
+		}
+		failed := node.Summary(elapsed).Errors
+		exposition.Add(PrometheusNamespace+"_node_failed_requests_total", "counter", "The requests the node failed in the current run.", labels, float64(failed))
+
+		recorder, ok := node.(latencyRecorder)
+		if ok {
+			help := "The latency of the requests answered by the node in the current run."
+			exposeHistogram(exposition, PrometheusNamespace+"_node_latency_milliseconds", help, recorder.Latencies(), labels)
+		}
+		waiter, ok := node.(waitRecorder)
+		if ok {
+			help := "How long requests queued for the node in the current run."
+			exposeHistogram(exposition, PrometheusNamespace+"_node_wait_milliseconds", help, waiter.Waits(), labels)
+		}
+	}
+}
+
+// exposeHistogram adds a histogram of the times a node measured in the
+// current run.
+func exposeHistogram(exposition *Exposition, name string, help string, histogram *Histogram, labels []string) {
+	below, count, sum := histogram.Cumulative(PrometheusLatencyBuckets)
+	for i, bound := range PrometheusLatencyBuckets {
+		le := append(append([]string{}, labels...), "le", strconv.FormatInt(bound, 10))
+		exposition.Add(name+"_bucket", "histogram", help, le, float64(below[i]))
+	}
+	exposition.Add(name+"_bucket", "histogram", help, append(append([]string{}, labels...), "le", "+Inf"), float64(count))
+	exposition.Add(name+"_sum", "histogram", help, labels, float64(sum))
+	exposition.Add(name+"_count", "histogram", help, labels, float64(count))
+}
+
+// prometheusName turns a node metric such as "Processed" in reqs into a
+// metric name such as systemdesigner_node_processed_requests_total.
+func prometheusName(metric Metric, kind string) string {
+	words := strings.FieldsFunc(strings.ToLower(metric.Name), func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	})
+	name := PrometheusNamespace + "_node_" + strings.Join(words, "_")
+
+	switch metric.Unit {
+	case "ms":
+		name += "_milliseconds"
+	case "%":
+		name += "_percent"
+	case "reqs":
+		name += "_requests"
+	}
+	if kind == "counter" {
+		name += "_total"
+	}
+	return name
+}
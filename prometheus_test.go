@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExposeTypes(t *testing.T) {
+	system, err := CompileDSL("client -> lb -> server -> db", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+
+	exposition := NewExposition()
+	system.Expose(exposition)
+	var text strings.Builder
+	_, err = exposition.WriteTo(&text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range []string{
+		"systemdesigner_node_processed_requests_total counter",
+		"systemdesigner_node_responses_total counter",
+		"systemdesigner_node_failed_requests_total counter",
+		"systemdesigner_node_queued_requests gauge",
+		"systemdesigner_node_utilisation_percent gauge",
+		"systemdesigner_node_latency_milliseconds histogram",
+		"systemdesigner_node_wait_milliseconds histogram",
+	} {
+		if !strings.Contains(text.String(), "# TYPE "+family+"\n") {
+			t.Errorf("missing %s in:\n%s", family, text.String())
+		}
+	}
+	if strings.Count(text.String(), " gauge\n") != 2 {
+		t.Errorf("expected only queued and utilisation gauges in:\n%s", text.String())
+	}
+}
@@ -3,20 +3,56 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-func RegisterRoutes() *mux.Router {
-	systemStore := NewSystemStore()
+func RegisterRoutes(storage Storage, limits Limits, exporter *TraceExporter) *mux.Router {
+	systemStore := NewSystemStore(storage, limits, exporter)
 	router := mux.NewRouter()
 
+	router.Methods(http.MethodGet).Path("/metrics").HandlerFunc(getPrometheusHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/templates").HandlerFunc(getTemplatesHandler())
+	router.Methods(http.MethodGet).Path("/api/systems").HandlerFunc(getSystemsHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/runs/compare").HandlerFunc(getCompareRunsHandler(systemStore))
 	router.Methods(http.MethodPost).Path("/api/systems").HandlerFunc(getCreateSystemHandler(systemStore))
+	router.Methods(http.MethodPost).Path("/api/systems/import").HandlerFunc(getImportSystemHandler(systemStore))
+	router.Methods(http.MethodPost).Path("/api/systems/dsl").HandlerFunc(getCompileSystemHandler(systemStore))
 	router.Methods(http.MethodGet).Path("/api/systems/{systemID}").HandlerFunc(getSystemHandler(systemStore))
+	router.Methods(http.MethodPatch).Path("/api/systems/{systemID}").HandlerFunc(getUpdateSystemHandler(systemStore))
+	router.Methods(http.MethodDelete).Path("/api/systems/{systemID}").HandlerFunc(getDeleteSystemHandler(systemStore))
+	router.Methods(http.MethodPost).Path("/api/systems/{systemID}/fork").HandlerFunc(getForkSystemHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/export").HandlerFunc(getExportSystemHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/history").HandlerFunc(getHistoryHandler(systemStore))
+	router.Methods(http.MethodPost).Path("/api/systems/{systemID}/undo").HandlerFunc(getHistoryActionHandler(systemStore, (*System).Undo))
+	router.Methods(http.MethodPost).Path("/api/systems/{systemID}/redo").HandlerFunc(getHistoryActionHandler(systemStore, (*System).Redo))
+	router.Methods(http.MethodPost).Path("/api/systems/{systemID}/snapshots").HandlerFunc(getCreateSnapshotHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/snapshots").HandlerFunc(getSnapshotsHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/snapshots/{snapshotID}").HandlerFunc(getSnapshotHandler(systemStore))
+	router.Methods(http.MethodPost).Path("/api/systems/{systemID}/snapshots/{snapshotID}/restore").HandlerFunc(getRestoreSnapshotHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/diff").HandlerFunc(getDiffHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/validate").HandlerFunc(getValidateSystemHandler(systemStore))
 	router.Methods(http.MethodPut).Path("/api/systems/{systemID}/start").HandlerFunc(getStartSystemHandler(systemStore))
+	router.Methods(http.MethodPut).Path("/api/systems/{systemID}/stop").HandlerFunc(getLifecycleHandler(systemStore, (*System).Stop))
+	router.Methods(http.MethodPut).Path("/api/systems/{systemID}/pause").HandlerFunc(getLifecycleHandler(systemStore, (*System).Pause))
+	router.Methods(http.MethodPut).Path("/api/systems/{systemID}/resume").HandlerFunc(getLifecycleHandler(systemStore, (*System).Resume))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/status").HandlerFunc(getSystemStatusHandler(systemStore))
+	router.Methods(http.MethodPut).Path("/api/systems/{systemID}/speed").HandlerFunc(getSetSpeedHandler(systemStore))
+	router.Methods(http.MethodPost).Path("/api/systems/{systemID}/step").HandlerFunc(getStepSystemHandler(systemStore))
 	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/metrics").HandlerFunc(getSystemMetricsHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/metrics/stream").HandlerFunc(getMetricsStreamHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/bottlenecks").HandlerFunc(getBottlenecksHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/runs").HandlerFunc(getRunsHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/runs/{runID}").HandlerFunc(getRunHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/runs/{runID}/metrics").HandlerFunc(getRunMetricsHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/runs/{runID}/traces").HandlerFunc(getRunTracesHandler(systemStore))
+	router.Methods(http.MethodGet).Path("/api/systems/{systemID}/runs/{runID}/traces/{requestID}").HandlerFunc(getTraceHandler(systemStore))
 
 	router.Methods(http.MethodPost).Path("/api/systems/{systemID}/nodes").HandlerFunc(getCreateNodeHandler(systemStore))
 	router.Methods(http.MethodPatch).Path("/api/systems/{systemID}/nodes/{nodeID}").HandlerFunc(getUpdateNodeHandler(systemStore))
@@ -28,29 +64,124 @@ func RegisterRoutes() *mux.Router {
 	return router
 }
 
+type CreateSystemRequest struct {
+	Template string `json:"template"`
+}
+
 type CreateSystemResponse struct {
-	ID string `json:"id"`
+	ID       string `json:"id"`
+	ParentID string `json:"parentId,omitempty"`
 }
 
-func getCreateSystemHandler(systemStore SystemStore) http.HandlerFunc {
+func getCreateSystemHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 
-		system := NewSystem()
-		system = DefaultSystem(system)
+		// The body is optional, an empty one creates the default template
+		body := CreateSystemRequest{Template: DefaultTemplate}
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil && !errors.Is(err, io.EOF) {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+		if body.Template == "" {
+			body.Template = DefaultTemplate
+		}
+
+		system, err := NewSystemFromTemplate(body.Template)
+		if errors.Is(err, ErrTemplateNotFound) {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
 
-		systemStore[system.ID] = system
+		err = systemStore.Add(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
 
 		writer.WriteHeader(http.StatusCreated)
-		err := json.NewEncoder(writer).Encode(CreateSystemResponse{ID: system.ID})
+		err = json.NewEncoder(writer).Encode(CreateSystemResponse{ID: system.ID})
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getTemplatesHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		err := json.NewEncoder(writer).Encode(Templates)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getSystemsHandler lists systems a page at a time. It filters by q in the
+// name or description, by every tag parameter and by parent.
+func getSystemsHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+
+		options := ListOptions{
+			Query:    query.Get("q"),
+			Tags:     query["tag"],
+			ParentID: query.Get("parent"),
+			Limit:    DefaultPageLimit,
+		}
+
+		var err error
+		if query.Get("offset") != "" {
+			options.Offset, err = strconv.Atoi(query.Get("offset"))
+			if err != nil || options.Offset < 0 {
+				encodeError(writer, fmt.Errorf("offset %q must be a non-negative number", query.Get("offset")), http.StatusBadRequest)
+				return
+			}
+		}
+		if query.Get("limit") != "" {
+			options.Limit, err = strconv.Atoi(query.Get("limit"))
+			if err != nil || options.Limit < 1 || options.Limit > MaxPageLimit {
+				encodeError(writer, fmt.Errorf("limit %q must be between 1 and %d", query.Get("limit"), MaxPageLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		list, err := systemStore.List(options)
 		if err != nil {
 			encodeError(writer, err, http.StatusInternalServerError)
 			return
 		}
+
+		err = json.NewEncoder(writer).Encode(list)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getDeleteSystemHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		err := systemStore.Delete(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
 	}
 }
 
 type GetSystemResponse struct {
-	ID    string         `json:"id"`
+	ID       string `json:"id"`
+	ParentID string `json:"parentId,omitempty"`
+	Metadata
 	Nodes []NodeResponse `json:"nodes"`
 	Edges []EdgeResponse `json:"edges"`
 }
@@ -66,44 +197,67 @@ type DataResponse struct {
 	Metrics []Metric `json:"metrics"`
 }
 
+func NewNodeResponse(node NodeRecord, metrics []Metric) NodeResponse {
+	return NodeResponse{
+		ID:       node.ID,
+		Position: node.Position,
+		Data:     DataResponse{Type: node.Type, Metrics: metrics},
+	}
+}
+
+// NewNodeResponses describes every node of the system with its live metrics.
+func NewNodeResponses(system *System) []NodeResponse {
+	metrics := system.Metrics()
+
+	responses := []NodeResponse{}
+	for _, node := range system.Record().Nodes {
+		responses = append(responses, NewNodeResponse(node, metrics[node.ID]))
+	}
+
+	return responses
+}
+
 type EdgeResponse struct {
 	ID     string `json:"id"`
 	Source string `json:"source"`
 	Target string `json:"target"`
 }
 
-func getSystemHandler(systemStore SystemStore) http.HandlerFunc {
+func NewEdgeResponse(edge Edge) EdgeResponse {
+	return EdgeResponse{
+		ID:     edge.ID,
+		Source: edge.SourceID,
+		Target: edge.TargetID,
+	}
+}
+
+func getSystemHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 
 		vars := mux.Vars(request)
 
-		system, ok := systemStore[vars["systemID"]]
-		if !ok {
-			encodeError(writer, errors.New("system not found"), http.StatusNotFound)
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
 			return
 		}
 
 		response := GetSystemResponse{
-			ID:    system.ID,
-			Nodes: []NodeResponse{},
-			Edges: []EdgeResponse{},
-		}
-		for _, node := range system.nodeStore {
-			response.Nodes = append(response.Nodes, NodeResponse{
-				ID:       node.GetID(),
-				Position: node.GetPosition(),
-				Data:     DataResponse{Type: node.GetType(), Metrics: node.GetMetrics()},
-			})
+			ID:       system.ID,
+			ParentID: system.ParentID,
+			Metadata: system.Metadata(),
+			Nodes:    NewNodeResponses(system),
+			Edges:    []EdgeResponse{},
 		}
-		for _, edge := range system.edgeStore {
+		for _, edge := range system.Record().Edges {
 			response.Edges = append(response.Edges, EdgeResponse{
 				ID:     edge.ID,
-				Source: edge.SourceID,
-				Target: edge.TargetID,
+				Source: edge.Source,
+				Target: edge.Target,
 			})
 		}
 
-		err := json.NewEncoder(writer).Encode(response)
+		err = json.NewEncoder(writer).Encode(response)
 		if err != nil {
 			encodeError(writer, err, http.StatusInternalServerError)
 			return
@@ -111,14 +265,63 @@ func getSystemHandler(systemStore SystemStore) http.HandlerFunc {
 	}
 }
 
-func getStartSystemHandler(systemStore SystemStore) http.HandlerFunc {
+type UpdateSystemRequest struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	Workload    *Workload `json:"workload"`
+	Seed        *int64    `json:"seed"`
+}
+
+func getUpdateSystemHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		vars := mux.Vars(request)
 
-		system := systemStore[vars["systemID"]]
-		err := system.Start()
+		var body UpdateSystemRequest
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		system, err := systemStore.Get(vars["systemID"])
 		if err != nil {
-			encodeError(writer, err, http.StatusInternalServerError)
+			encodeSystemError(writer, err)
+			return
+		}
+
+		if body.Name != nil || body.Description != nil || body.Tags != nil {
+			metadata := system.Metadata()
+			if body.Name != nil {
+				metadata.Name = *body.Name
+			}
+			if body.Description != nil {
+				metadata.Description = *body.Description
+			}
+			if body.Tags != nil {
+				metadata.Tags = *body.Tags
+			}
+
+			err = system.SetMetadata(metadata)
+			if err != nil {
+				encodeError(writer, err, http.StatusBadRequest)
+				return
+			}
+		}
+		if body.Workload != nil {
+			err = system.SetWorkload(*body.Workload)
+			if err != nil {
+				encodeError(writer, err, http.StatusBadRequest)
+				return
+			}
+		}
+		if body.Seed != nil {
+			system.SetSeed(*body.Seed)
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
 			return
 		}
 
@@ -126,86 +329,97 @@ func getStartSystemHandler(systemStore SystemStore) http.HandlerFunc {
 	}
 }
 
-func getSystemMetricsHandler(systemStore SystemStore) http.HandlerFunc {
+func getForkSystemHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		var upgrader = websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
 		}
 
-		vars := mux.Vars(request)
+		forked, err := system.Fork()
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
 
-		system, exists := systemStore[vars["systemID"]]
-		if !exists {
-			encodeError(writer, errors.New("system not found"), http.StatusNotFound)
+		err = systemStore.Add(forked)
+		if err != nil {
+			encodeSystemError(writer, err)
 			return
 		}
-		messages := system.messages
 
-		conn, err := upgrader.Upgrade(writer, request, nil)
+		writer.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(writer).Encode(CreateSystemResponse{ID: forked.ID, ParentID: forked.ParentID})
 		if err != nil {
 			encodeError(writer, err, http.StatusInternalServerError)
 			return
 		}
-		defer conn.Close()
+	}
+}
 
-		done := make(chan struct{})
-		go func() {
-			defer close(done)
-			for {
-				_, _, err := conn.ReadMessage()
-				if err != nil {
-					log.Print("read error: ", err)
-					return
-				}
-			}
-		}()
+func getExportSystemHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
 
-		for {
-			select {
-			case <-done:
-				return
-			case msg := <-messages:
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
 
-				msgBytes, err := json.Marshal(msg)
-				if err != nil {
-					log.Print("marshall error: ", err)
-					continue
-				}
+		format := DocumentFormat(request.URL.Query().Get("format"), request.Header.Get("Accept"))
+		documentBytes, err := EncodeSystemDocument(NewSystemDocument(system.Record()), format)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
 
-				err = conn.WriteMessage(websocket.TextMessage, msgBytes)
-				if err != nil {
-					log.Print("write error: ", err)
-					continue
-				}
-			}
+		writer.Header().Set("Content-Type", "application/"+format)
+		_, err = writer.Write(documentBytes)
+		if err != nil {
+			log.Print("write error: ", err)
 		}
 	}
 }
 
-type CreateNodeRequest struct {
-	Type string `json:"type"`
-}
-
-func getCreateNodeHandler(systemStore SystemStore) http.HandlerFunc {
+func getImportSystemHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		vars := mux.Vars(request)
+		documentBytes, err := io.ReadAll(request.Body)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
 
-		var body CreateNodeRequest
-		err := json.NewDecoder(request.Body).Decode(&body)
+		format := DocumentFormat(request.URL.Query().Get("format"), request.Header.Get("Content-Type"))
+		document, err := DecodeSystemDocument(documentBytes, format)
 		if err != nil {
-			encodeError(writer, err, http.StatusInternalServerError)
+			encodeError(writer, fmt.Errorf("invalid %s document: %w", format, err), http.StatusBadRequest)
+			return
+		}
+
+		record, err := document.Record()
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		system, err := NewSystemFromRecord(record)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
 			return
 		}
 
-		system := systemStore[vars["systemID"]]
-		newNode := system.AddNode(body.Type)
+		err = systemStore.Add(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
 
 		writer.WriteHeader(http.StatusCreated)
-		err = json.NewEncoder(writer).Encode(newNode)
+		err = json.NewEncoder(writer).Encode(CreateSystemResponse{ID: system.ID})
 		if err != nil {
 			encodeError(writer, err, http.StatusInternalServerError)
 			return
@@ -213,81 +427,1174 @@ func getCreateNodeHandler(systemStore SystemStore) http.HandlerFunc {
 	}
 }
 
-type UpdateNodeRequest struct {
-	X float64 `json:"x"`
-	Y float64 `json:"y"`
+// getCompileSystemHandler creates a system from a design written in the DSL.
+// Syntax errors are returned as JSON with the line and column they are at.
+func getCompileSystemHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		sourceBytes, err := io.ReadAll(request.Body)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		system, err := CompileDSL(string(sourceBytes), systemStore.limits)
+		var dslError *DSLError
+		if errors.As(err, &dslError) {
+			log.Print(err)
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			err = json.NewEncoder(writer).Encode(dslError)
+			if err != nil {
+				log.Print("write error: ", err)
+			}
+			return
+		} else if errors.Is(err, ErrLimitExceeded) {
+			encodeSystemError(writer, err)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = systemStore.Add(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(writer).Encode(CreateSystemResponse{ID: system.ID})
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
 }
 
-func getUpdateNodeHandler(systemStore SystemStore) http.HandlerFunc {
+func getHistoryHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		vars := mux.Vars(request)
 
-		var body UpdateNodeRequest
-		err := json.NewDecoder(request.Body).Decode(&body)
+		system, err := systemStore.Get(vars["systemID"])
 		if err != nil {
-			encodeError(writer, err, http.StatusInternalServerError)
+			encodeSystemError(writer, err)
 			return
 		}
 
-		system := systemStore[vars["systemID"]]
-		system.nodeStore[vars["nodeID"]].SetPosition(Position{
-			X: int(body.X),
-			Y: int(body.Y),
-		})
-
-		writer.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(writer).Encode(system.History())
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
-func getDeleteNodesHandler(systemStore SystemStore) http.HandlerFunc {
+// getHistoryActionHandler undoes or redoes an edit and responds with the
+// resulting history.
+func getHistoryActionHandler(systemStore *SystemStore, action func(*System) (HistoryStatus, error)) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		vars := mux.Vars(request)
 
-		system := systemStore[vars["systemID"]]
-		for _, nodeID := range request.URL.Query()["id"] {
-			log.Printf("deleting system %s node %s", system.ID, nodeID)
-			delete(system.nodeStore, nodeID)
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
 		}
 
-		writer.WriteHeader(http.StatusOK)
+		history, err := action(system)
+		if errors.Is(err, ErrNothingToUndo) || errors.Is(err, ErrNothingToRedo) || errors.Is(err, ErrInvalidState) {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(history)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
-type CreateConnectionRequest struct {
-	SourceID string `json:"source"`
-	TargetID string `json:"target"`
+type CreateSnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// SnapshotResponse describes a snapshot without its design.
+type SnapshotResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func NewSnapshotResponse(snapshot Snapshot) SnapshotResponse {
+	return SnapshotResponse{
+		ID:        snapshot.ID,
+		Name:      snapshot.Name,
+		CreatedAt: snapshot.CreatedAt,
+	}
 }
 
-func getCreateEdgeHandler(systemStore SystemStore) http.HandlerFunc {
+func getCreateSnapshotHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		vars := mux.Vars(request)
 
-		var body CreateConnectionRequest
+		var body CreateSnapshotRequest
 		err := json.NewDecoder(request.Body).Decode(&body)
 		if err != nil {
-			encodeError(writer, err, http.StatusInternalServerError)
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+		if body.Name == "" {
+			encodeError(writer, errors.New("snapshot name must not be empty"), http.StatusBadRequest)
 			return
 		}
 
-		system := systemStore[vars["systemID"]]
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
 
-		system.AddEdge(body.SourceID, body.TargetID)
+		snapshot, err := systemStore.SaveSnapshot(system, body.Name)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
 
 		writer.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(writer).Encode(NewSnapshotResponse(snapshot))
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
-func getDeleteEdgesHandler(systemStore SystemStore) http.HandlerFunc {
+func getSnapshotsHandler(systemStore *SystemStore) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		vars := mux.Vars(request)
 
-		system := systemStore[vars["systemID"]]
-		for _, edgeID := range request.URL.Query()["id"] {
-			log.Printf("deleting system %s edge %s", system.ID, edgeID)
-			delete(system.edgeStore, edgeID)
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		snapshots, err := systemStore.Snapshots(system.ID)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
 		}
 
-		writer.WriteHeader(http.StatusOK)
+		response := []SnapshotResponse{}
+		for _, snapshot := range snapshots {
+			response = append(response, NewSnapshotResponse(snapshot))
+		}
+
+		err = json.NewEncoder(writer).Encode(response)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getSnapshotHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		snapshot, err := systemStore.Snapshot(system.ID, vars["snapshotID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(snapshot)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getRestoreSnapshotHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		snapshot, err := systemStore.Snapshot(system.ID, vars["snapshotID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+		history, err := system.RestoreSnapshot(snapshot)
+		if errors.Is(err, ErrInvalidState) {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(history)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getDiffHandler compares two snapshots, given by the from and to query
+// parameters. Leaving either out compares against the current design.
+func getDiffHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		records := []SystemRecord{}
+		for _, snapshotID := range []string{request.URL.Query().Get("from"), request.URL.Query().Get("to")} {
+			if snapshotID == "" {
+				records = append(records, system.Record())
+				continue
+			}
+
+			snapshot, err := systemStore.Snapshot(system.ID, snapshotID)
+			if err != nil {
+				encodeSystemError(writer, err)
+				return
+			}
+			records = append(records, snapshot.Record)
+		}
+
+		err = json.NewEncoder(writer).Encode(DiffRecords(records[0], records[1]))
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getValidateSystemHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		encodeValidation(writer, system.Validate(), http.StatusOK)
+	}
+}
+
+func getStartSystemHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+		err = system.Start()
+		var validationError *ValidationError
+		if errors.As(err, &validationError) {
+			encodeValidation(writer, validationError.Validation, http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, ErrTooManyRuns) {
+			encodeSystemError(writer, err)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}
+}
+
+// getLifecycleHandler applies a run transition such as stop, pause or resume
+// and responds with the resulting status.
+func getLifecycleHandler(systemStore *SystemStore, transition func(*System) error) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = transition(system)
+		if errors.Is(err, ErrInvalidState) {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(system.Status())
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getSystemStatusHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(system.Status())
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+type StepResponse struct {
+	Event  Event          `json:"event"`
+	Nodes  []NodeResponse `json:"nodes"`
+	Status SystemStatus   `json:"status"`
+}
+
+func getStepSystemHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		event, err := system.Step()
+		var validationError *ValidationError
+		if errors.As(err, &validationError) {
+			encodeValidation(writer, validationError.Validation, http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, ErrInvalidState) {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		} else if errors.Is(err, ErrTooManyRuns) {
+			encodeSystemError(writer, err)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		response := StepResponse{
+			Event:  event,
+			Nodes:  NewNodeResponses(system),
+			Status: system.Status(),
+		}
+
+		err = json.NewEncoder(writer).Encode(response)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+type SetSpeedRequest struct {
+	Speed float64 `json:"speed"`
+}
+
+func getSetSpeedHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		var body SetSpeedRequest
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = system.SetSpeed(body.Speed)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(system.Status())
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getSystemMetricsHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var upgrader = websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		}
+
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+		conn, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+
+		subscription := system.Subscribe()
+		defer system.Unsubscribe(subscription)
+
+		// Only this goroutine writes to the connection, so answers to commands
+		// are handed over on replies
+		filter := &SocketFilter{}
+		replies := make(chan Message, 16)
+		done := make(chan struct{})
+		closing := make(chan struct{})
+		defer close(closing)
+
+		go func() {
+			defer close(done)
+			for {
+				_, msgBytes, err := conn.ReadMessage()
+				if err != nil {
+					log.Print("read error: ", err)
+					return
+				}
+
+				var command SocketCommand
+				var reply Message
+				err = json.Unmarshal(msgBytes, &command)
+				if err != nil {
+					reply = NewSocketError(command, fmt.Errorf("%w: %v", ErrInvalidCommand, err))
+				} else {
+					reply = handleSocketCommand(systemStore, system, filter, command)
+				}
+				if reply.Type == ErrorMessage {
+					log.Printf("system %s command %q failed: %s", system.ID, command.Type, reply.Error)
+				}
+
+				select {
+				case replies <- reply:
+				case <-closing:
+					return
+				}
+			}
+		}()
+
+		for {
+			var msg Message
+			select {
+			case <-done:
+				return
+			case msg = <-replies:
+			case published, ok := <-subscription.Messages():
+				if !ok {
+					return
+				}
+				msg, ok = filter.Apply(published)
+				if !ok {
+					continue
+				}
+			}
+
+			msgBytes, err := json.Marshal(msg)
+			if err != nil {
+				log.Print("marshall error: ", err)
+				continue
+			}
+
+			err = conn.WriteMessage(websocket.TextMessage, msgBytes)
+			if err != nil {
+				log.Print("write error: ", err)
+				continue
+			}
+		}
+	}
+}
+
+// StreamKeepAlive is how often an idle event stream sends a comment, so that
+// proxies do not close it.
+const StreamKeepAlive = 15 * time.Second
+
+// getMetricsStreamHandler serves the websocket's messages as server-sent
+// events, for clients behind proxies that break websockets. Each event's ID
+// can be sent back as Last-Event-ID to resume after a dropped connection.
+func getMetricsStreamHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		flusher, ok := writer.(http.Flusher)
+		if !ok {
+			encodeError(writer, errors.New("streaming is not supported"), http.StatusInternalServerError)
+			return
+		}
+
+		var subscription *Subscription
+		lastEventID := request.Header.Get("Last-Event-ID")
+		if lastEventID != "" {
+			seq, err := strconv.ParseInt(lastEventID, 10, 64)
+			if err != nil {
+				encodeError(writer, fmt.Errorf("invalid Last-Event-ID %q", lastEventID), http.StatusBadRequest)
+				return
+			}
+			subscription = system.SubscribeAfter(seq)
+		} else {
+			subscription = system.Subscribe()
+		}
+		defer system.Unsubscribe(subscription)
+
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.Header().Set("Connection", "keep-alive")
+		writer.Header().Set("X-Accel-Buffering", "no")
+		writer.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(StreamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-request.Context().Done():
+				return
+			case <-keepAlive.C:
+				_, err = fmt.Fprint(writer, ": keep-alive\n\n")
+			case msg, ok := <-subscription.Messages():
+				if !ok {
+					return
+				}
+
+				var msgBytes []byte
+				msgBytes, err = json.Marshal(msg)
+				if err != nil {
+					log.Print("marshall error: ", err)
+					continue
+				}
+				_, err = fmt.Fprintf(writer, "id: %d\ndata: %s\n\n", msg.Seq, msgBytes)
+			}
+			if err != nil {
+				log.Print("write error: ", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// RunResponse describes a run without its design.
+type RunResponse struct {
+	ID        string      `json:"id"`
+	State     string      `json:"state"`
+	Reason    string      `json:"reason,omitempty"`
+	StartedAt time.Time   `json:"startedAt"`
+	EndedAt   *time.Time  `json:"endedAt,omitempty"`
+	Seed      int64       `json:"seed"`
+	Summary   *RunSummary `json:"summary,omitempty"`
+}
+
+func NewRunResponse(record RunRecord) RunResponse {
+	return RunResponse{
+		ID:        record.ID,
+		State:     record.State,
+		Reason:    record.Reason,
+		StartedAt: record.StartedAt,
+		EndedAt:   record.EndedAt,
+		Seed:      record.Seed,
+		Summary:   record.Summary,
+	}
+}
+
+func getRunsHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		records, err := systemStore.Runs(system)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		response := []RunResponse{}
+		for _, record := range records {
+			response = append(response, NewRunResponse(record))
+		}
+
+		err = json.NewEncoder(writer).Encode(response)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getRunHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		record, err := systemStore.Run(system, vars["runID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(record)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getBottlenecksHandler ranks the nodes holding back the run given by the
+// run query parameter, by default the current or latest one.
+func getBottlenecksHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		analysis, err := system.Analyze(request.URL.Query().Get("run"))
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(analysis)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getPrometheusHandler exposes the node metrics of every active system in
+// the Prometheus text format, for scraping.
+func getPrometheusHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		exposition := NewExposition()
+		for _, system := range systemStore.Active() {
+			system.Expose(exposition)
+		}
+
+		writer.Header().Set("Content-Type", PrometheusContentType)
+		_, err := exposition.WriteTo(writer)
+		if err != nil {
+			log.Print("write error: ", err)
+		}
+	}
+}
+
+// getCompareRunsHandler compares the candidate runs with the baseline runs,
+// each given as systemID/runID. A side can repeat its parameter to pool runs
+// with different seeds.
+func getCompareRunsHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		values := request.URL.Query()
+
+		sides := [][]RunRecord{}
+		for _, name := range []string{"baseline", "candidate"} {
+			records := []RunRecord{}
+			for _, value := range values[name] {
+				ref, err := ParseRunRef(value)
+				if err != nil {
+					encodeSystemError(writer, err)
+					return
+				}
+
+				record, err := systemStore.FindRunRecord(ref)
+				if err != nil {
+					encodeSystemError(writer, err)
+					return
+				}
+				records = append(records, record)
+			}
+			sides = append(sides, records)
+		}
+
+		comparison, err := CompareRuns(sides[0], sides[1])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(comparison)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getRunMetricsHandler returns the metrics recorded during a run as series
+// for charting. It accepts repeated node and metric filters, and from, to
+// and step in simulated milliseconds.
+func getRunMetricsHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+		values := request.URL.Query()
+
+		query := SeriesQuery{
+			Nodes:   values["node"],
+			Metrics: values["metric"],
+		}
+		bounds := map[string]*int{
+			"from": &query.From,
+			"to":   &query.To,
+			"step": &query.Step,
+		}
+		for name, bound := range bounds {
+			if values.Get(name) == "" {
+				continue
+			}
+			value, err := strconv.Atoi(values.Get(name))
+			if err != nil {
+				encodeError(writer, fmt.Errorf("%s must be a whole number of milliseconds", name), http.StatusBadRequest)
+				return
+			}
+			*bound = value
+		}
+		err := query.Validate()
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		run, err := system.FindRun(vars["runID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(run.Query(query))
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getRunTracesHandler downloads the traces of a run as OTLP-JSON or, with
+// format=jaeger, as Jaeger JSON.
+func getRunTracesHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		run, err := system.FindRun(vars["runID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		format := request.URL.Query().Get("format")
+		if format == "" {
+			format = OTLPFormat
+		}
+		traces, err := run.ExportTraces(format)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", run.ID+"-traces."+format+".json"))
+		err = json.NewEncoder(writer).Encode(traces)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getTraceHandler returns the waterfall of one request of a run. With more
+// than one client, the client parameter picks whose request it is.
+func getTraceHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		run, err := system.FindRun(vars["runID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		requestID, err := strconv.Atoi(vars["requestID"])
+		if err != nil {
+			encodeError(writer, fmt.Errorf("request ID %q is not a number", vars["requestID"]), http.StatusBadRequest)
+			return
+		}
+
+		waterfall, err := run.Trace(request.URL.Query().Get("client"), requestID)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = json.NewEncoder(writer).Encode(waterfall)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+type CreateNodeRequest struct {
+	Type string `json:"type"`
+}
+
+func getCreateNodeHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		var body CreateNodeRequest
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		if !IsNodeType(body.Type) {
+			encodeError(writer, fmt.Errorf("unknown node type %q", body.Type), http.StatusBadRequest)
+			return
+		}
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+		newNode, err := system.AddNode(body.Type)
+		if errors.Is(err, ErrInvalidState) {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(writer).Encode(newNode)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+type UpdateNodeRequest struct {
+	X      *float64    `json:"x"`
+	Y      *float64    `json:"y"`
+	Config *NodeConfig `json:"config"`
+}
+
+func getUpdateNodeHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		var body UpdateNodeRequest
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		if (body.X == nil) != (body.Y == nil) {
+			encodeError(writer, errors.New("x and y must be given together"), http.StatusBadRequest)
+			return
+		}
+		var position *Position
+		if body.X != nil {
+			position = &Position{
+				X: int(*body.X),
+				Y: int(*body.Y),
+			}
+		}
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+		err = system.UpdateNode(vars["nodeID"], body.Config, position)
+		if errors.Is(err, ErrNodeNotFound) {
+			encodeError(writer, err, http.StatusNotFound)
+			return
+		} else if errors.Is(err, ErrInvalidState) {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}
+}
+
+// DeleteNodesResponse lists the edges removed along with the nodes.
+type DeleteNodesResponse struct {
+	Edges []EdgeResponse `json:"edges"`
+}
+
+func getDeleteNodesHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+		edges, err := system.DeleteNodes(request.URL.Query()["id"])
+		if err != nil {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		response := DeleteNodesResponse{Edges: []EdgeResponse{}}
+		for _, edge := range edges {
+			response.Edges = append(response.Edges, NewEdgeResponse(edge))
+		}
+
+		err = json.NewEncoder(writer).Encode(response)
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+type CreateConnectionRequest struct {
+	SourceID string `json:"source"`
+	TargetID string `json:"target"`
+}
+
+func getCreateEdgeHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		var body CreateConnectionRequest
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		edge, err := system.AddEdge(body.SourceID, body.TargetID)
+		if errors.Is(err, ErrNodeNotFound) || errors.Is(err, ErrLimitExceeded) {
+			encodeSystemError(writer, err)
+			return
+		} else if errors.Is(err, ErrInvalidState) {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			encodeError(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(writer).Encode(NewEdgeResponse(edge))
+		if err != nil {
+			encodeError(writer, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func getDeleteEdgesHandler(systemStore *SystemStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		system, err := systemStore.Get(vars["systemID"])
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+		err = system.DeleteEdges(request.URL.Query()["id"])
+		if err != nil {
+			encodeError(writer, err, http.StatusConflict)
+			return
+		}
+
+		err = systemStore.Save(system)
+		if err != nil {
+			encodeSystemError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}
+}
+
+// encodeSystemError responds to a failed system lookup or one refused by
+// the store's limits.
+func encodeSystemError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrSystemNotFound) || errors.Is(err, ErrSnapshotNotFound) || errors.Is(err, ErrRunNotFound) || errors.Is(err, ErrTraceNotFound) {
+		encodeError(writer, err, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrInvalidComparison) || errors.Is(err, ErrUnknownTraceFormat) || errors.Is(err, ErrAmbiguousRequest) {
+		encodeError(writer, err, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrSystemUnloaded) {
+		encodeError(writer, err, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrLimitExceeded) {
+		encodeError(writer, err, http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, ErrTooManyRuns) {
+		writer.Header().Set("Retry-After", "30")
+		encodeError(writer, err, http.StatusTooManyRequests)
+		return
+	}
+
+	encodeError(writer, err, http.StatusInternalServerError)
+}
+
+func encodeValidation(writer http.ResponseWriter, validation Validation, code int) {
+	if !validation.Valid {
+		log.Printf("%d design errors, first: %s", len(validation.Errors), validation.Errors[0].Message)
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	err := json.NewEncoder(writer).Encode(validation)
+	if err != nil {
+		log.Print("write error: ", err)
 	}
 }
 
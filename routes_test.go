@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the API from a store in a temporary directory.
+func newTestServer(t *testing.T, limits Limits) *httptest.Server {
+	t.Helper()
+
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(RegisterRoutes(storage, limits, nil))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// call sends a request with an optional JSON body and returns the status and
+// body of the response.
+func call(t *testing.T, method string, url string, body string) (int, []byte) {
+	t.Helper()
+
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	bytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, bytes
+}
+
+func createSystem(t *testing.T, server *httptest.Server, template string) GetSystemResponse {
+	t.Helper()
+
+	status, body := call(t, http.MethodPost, server.URL+"/api/systems", fmt.Sprintf(`{"template": %q}`, template))
+	if status != http.StatusOK && status != http.StatusCreated {
+		t.Fatalf("creating a system returned %d: %s", status, body)
+	}
+	var created CreateSystemResponse
+	err := json.Unmarshal(body, &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = call(t, http.MethodGet, server.URL+"/api/systems/"+created.ID, "")
+	if status != http.StatusOK {
+		t.Fatalf("getting system %s returned %d: %s", created.ID, status, body)
+	}
+	var system GetSystemResponse
+	err = json.Unmarshal(body, &system)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return system
+}
+
+// TestConcurrentAPI edits, runs and reads one system from many clients at
+// once. Run it with -race.
+func TestConcurrentAPI(t *testing.T) {
+	limits := DefaultLimits()
+	limits.IdleTTL = 0
+	server := newTestServer(t, limits)
+
+	system := createSystem(t, server, "three-tier")
+	base := server.URL + "/api/systems/" + system.ID
+	var serverID string
+	for _, node := range system.Nodes {
+		if node.Data.Type == ServerType {
+			serverID = node.ID
+		}
+	}
+
+	call(t, http.MethodPut, base+"/speed", `{"speed": 1}`)
+	call(t, http.MethodPut, base+"/start", "")
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPut, "/start", ""},
+		{http.MethodPut, "/pause", ""},
+		{http.MethodPut, "/resume", ""},
+		{http.MethodPut, "/stop", ""},
+		{http.MethodPut, "/speed", `{"speed": 2}`},
+		{http.MethodGet, "/status", ""},
+		{http.MethodGet, "", ""},
+		{http.MethodGet, "/runs", ""},
+		{http.MethodGet, "/bottlenecks", ""},
+		{http.MethodGet, "/validate", ""},
+		{http.MethodGet, "/history", ""},
+		{http.MethodPatch, "/nodes/" + serverID, `{"config": {"maxRoutines": 5, "processingTimeLower": 50, "processingTimeUpper": 100}}`},
+		{http.MethodPatch, "/nodes/" + serverID, `{"x": 10, "y": 20}`},
+		{http.MethodPost, "/nodes", `{"type": "server"}`},
+		{http.MethodPatch, "", `{"name": "busy"}`},
+	}
+
+	var wg sync.WaitGroup
+	failures := make(chan string, 1000)
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 30; i++ {
+				r := requests[(worker*7+i)%len(requests)]
+				status, body := call(t, r.method, base+r.path, r.body)
+				if status >= http.StatusInternalServerError {
+					failures <- fmt.Sprintf("%s %s returned %d: %s", r.method, r.path, status, body)
+				}
+			}
+		}(worker)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 30; i++ {
+			call(t, http.MethodGet, server.URL+"/metrics", "")
+			call(t, http.MethodGet, server.URL+"/api/systems", "")
+		}
+	}()
+
+	wg.Wait()
+	close(failures)
+	for failure := range failures {
+		t.Error(failure)
+	}
+
+	call(t, http.MethodPut, base+"/stop", "")
+}
+
+// TestCompileOverLimits rejects a design that fans out past the limits
+// before building any of it.
+func TestCompileOverLimits(t *testing.T) {
+	server := newTestServer(t, DefaultLimits())
+
+	status, body := call(t, http.MethodPost, server.URL+"/api/systems/dsl", "client -> [server x40] -> [db x40]")
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("compiling 1,640 edges returned %d: %s", status, body)
+	}
+
+	status, body = call(t, http.MethodPost, server.URL+"/api/systems/dsl", "client -> [server x3] -> db")
+	if status != http.StatusCreated {
+		t.Errorf("compiling a small design returned %d: %s", status, body)
+	}
+}
+
+func TestNodeRequestErrors(t *testing.T) {
+	server := newTestServer(t, DefaultLimits())
+	system := createSystem(t, server, "three-tier")
+	base := server.URL + "/api/systems/" + system.ID
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/nodes", `{"type": `, http.StatusBadRequest},
+		{http.MethodPost, "/nodes", `{"type": "mainframe"}`, http.StatusBadRequest},
+		{http.MethodPatch, "/nodes/" + system.Nodes[0].ID, `{"x": "left"}`, http.StatusBadRequest},
+		{http.MethodPatch, "/nodes/" + system.Nodes[0].ID, `{"x": 10}`, http.StatusBadRequest},
+		{http.MethodPatch, "/nodes/" + system.Nodes[0].ID, `{"y": 20}`, http.StatusBadRequest},
+		{http.MethodPatch, "/nodes/missing", `{"x": 10, "y": 20}`, http.StatusNotFound},
+		{http.MethodPatch, "/nodes/" + system.Nodes[0].ID, `{"x": 10, "y": 20}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		status, body := call(t, test.method, base+test.path, test.body)
+		if status != test.status {
+			t.Errorf("%s %s %s returned %d, expected %d: %s", test.method, test.path, test.body, status, test.status, body)
+		}
+	}
+}
+
+// TestEditWhileRunning refuses design edits until the run is stopped, while
+// still letting nodes be moved.
+func TestEditWhileRunning(t *testing.T) {
+	server := newTestServer(t, DefaultLimits())
+	system := createSystem(t, server, "three-tier")
+	base := server.URL + "/api/systems/" + system.ID
+	var clientID, serverID string
+	for _, node := range system.Nodes {
+		switch node.Data.Type {
+		case ClientType:
+			clientID = node.ID
+		case ServerType:
+			serverID = node.ID
+		}
+	}
+
+	call(t, http.MethodPut, base+"/start", "")
+	status, body := call(t, http.MethodPut, base+"/pause", "")
+	if status != http.StatusOK {
+		t.Fatalf("pausing returned %d: %s", status, body)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/nodes", `{"type": "server"}`, http.StatusConflict},
+		{http.MethodPatch, "/nodes/" + serverID, `{"config": {"maxRoutines": 5}}`, http.StatusConflict},
+		{http.MethodPost, "/edges", fmt.Sprintf(`{"source": %q, "target": %q}`, clientID, serverID), http.StatusConflict},
+		{http.MethodDelete, "/edges?id=" + system.Edges[0].ID, "", http.StatusConflict},
+		{http.MethodDelete, "/nodes?id=" + serverID, "", http.StatusConflict},
+		{http.MethodPatch, "/nodes/" + serverID, `{"x": 10, "y": 20}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		status, body := call(t, test.method, base+test.path, test.body)
+		if status != test.status {
+			t.Errorf("%s %s %s returned %d, expected %d: %s", test.method, test.path, test.body, status, test.status, body)
+		}
+	}
+
+	call(t, http.MethodPut, base+"/stop", "")
+	status, body = call(t, http.MethodPost, base+"/nodes", `{"type": "server"}`)
+	if status != http.StatusCreated {
+		t.Errorf("adding a node after stopping returned %d: %s", status, body)
+	}
+}
+
+// TestUpdateNodeIsOneEdit changes a node's config and position together,
+// as a single edit that is applied whole or not at all.
+func TestUpdateNodeIsOneEdit(t *testing.T) {
+	server := newTestServer(t, DefaultLimits())
+	system := createSystem(t, server, "three-tier")
+	base := server.URL + "/api/systems/" + system.ID
+	var serverID string
+	for _, node := range system.Nodes {
+		if node.Data.Type == ServerType {
+			serverID = node.ID
+		}
+	}
+	history := func() HistoryStatus {
+		t.Helper()
+
+		_, body := call(t, http.MethodGet, base+"/history", "")
+		var status HistoryStatus
+		err := json.Unmarshal(body, &status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	status, body := call(t, http.MethodPatch, base+"/nodes/"+serverID, `{"x": 10, "y": 20, "config": {"maxRoutines": -1}}`)
+	if status != http.StatusBadRequest {
+		t.Errorf("an invalid config returned %d: %s", status, body)
+	}
+	if undo := history().Undo; len(undo) != 0 {
+		t.Errorf("a rejected update was recorded as %v", undo)
+	}
+
+	status, body = call(t, http.MethodPatch, base+"/nodes/"+serverID, `{"x": 10, "y": 20, "config": {"maxRoutines": 7}}`)
+	if status != http.StatusOK {
+		t.Fatalf("updating the node returned %d: %s", status, body)
+	}
+	if undo := history().Undo; len(undo) != 1 || undo[0] != "configure "+ServerType {
+		t.Errorf("the update was recorded as %v", undo)
+	}
+
+	var updated GetSystemResponse
+	_, body = call(t, http.MethodGet, base, "")
+	err := json.Unmarshal(body, &updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range updated.Nodes {
+		if node.ID == serverID && (node.Position.X != 10 || node.Position.Y != 20) {
+			t.Errorf("the node is at %v", node.Position)
+		}
+	}
+}
+
+// streamEvents connects to a system's event stream, optionally resuming
+// after lastEventID, and returns the first n events as messages numbered
+// by their IDs.
+func streamEvents(t *testing.T, url string, lastEventID string, n int) []Message {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	messages := []Message{}
+	var msg Message
+	scanner := bufio.NewScanner(response.Body)
+	for len(messages) < n && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			msg.Seq, err = strconv.ParseInt(strings.TrimPrefix(line, "id: "), 10, 64)
+		case strings.HasPrefix(line, "data: "):
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg)
+		case line == "" && msg.Type != "":
+			messages = append(messages, msg)
+			msg = Message{}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(messages) < n {
+		t.Fatalf("the stream ended after %d events: %v", len(messages), scanner.Err())
+	}
+	return messages
+}
+
+// TestMetricsStreamResume reconnects to the event stream with the last ID
+// seen, and receives only the messages published since.
+func TestMetricsStreamResume(t *testing.T) {
+	server := newTestServer(t, DefaultLimits())
+	system := createSystem(t, server, "three-tier")
+	base := server.URL + "/api/systems/" + system.ID
+
+	last := streamEvents(t, base+"/metrics/stream", "", 1)[0].Seq
+	for i := 0; i < 3; i++ {
+		status, body := call(t, http.MethodPatch, base+"/nodes/"+system.Nodes[0].ID, fmt.Sprintf(`{"x": %d, "y": 0}`, i))
+		if status != http.StatusOK {
+			t.Fatalf("moving a node returned %d: %s", status, body)
+		}
+	}
+
+	messages := streamEvents(t, base+"/metrics/stream", strconv.FormatInt(last, 10), 3)
+	for i, msg := range messages {
+		if msg.Seq != last+int64(i)+1 || msg.Type != HistoryMessage {
+			t.Errorf("resumed event %d is %s numbered %d, expected history numbered %d", i, msg.Type, msg.Seq, last+int64(i)+1)
+		}
+	}
+
+	// An ID the stream never sent starts again from the current picture,
+	// numbered as of now rather than replaying anything
+	first := streamEvents(t, base+"/metrics/stream", strconv.FormatInt(last+100, 10), 1)[0]
+	if first.Seq < last+3 {
+		t.Errorf("starting afresh sent %s numbered %d first, before the latest %d", first.Type, first.Seq, last+3)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lithammer/shortuuid/v3"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrRunNotFound = errors.New("run not found")
+
+const (
+	// MaxRuns is how many of its most recent runs a system keeps.
+	MaxRuns = 10
+	// MaxSeriesPoints is how many samples of each node metric a run keeps,
+	// dropping the oldest beyond that.
+	MaxSeriesPoints = 10000
+	// MaxQueryPoints is how many points a series is downsampled to when no
+	// step is asked for.
+	MaxQueryPoints = 500
+	// RecorderBuffer is how far a run's recorder can fall behind the
+	// published messages.
+	RecorderBuffer = 4096
+)
+
+// StoppedState ends a run that was stopped, restarted or closed before it
+// could complete.
+const StoppedState string = "stopped"
+
+// RunRecord is the lasting account of a run: the design and seed it ran
+// with, how it ended and a summary of how it went.
+type RunRecord struct {
+	ID        string       `json:"id"`
+	SystemID  string       `json:"systemId"`
+	State     string       `json:"state"`
+	Reason    string       `json:"reason,omitempty"`
+	StartedAt time.Time    `json:"startedAt"`
+	EndedAt   *time.Time   `json:"endedAt,omitempty"`
+	Seed      int64        `json:"seed"`
+	Design    SystemRecord `json:"design"`
+	Summary   *RunSummary  `json:"summary,omitempty"`
+}
+
+// Run is one simulation of a system, from its start until it completes,
+// fails or is stopped. It records the metrics published along the way. It
+// is safe for concurrent use.
+type Run struct {
+	ID        string
+	StartedAt time.Time
+
+	mutex     sync.RWMutex
+	record    RunRecord
+	series    map[string]*Series
+	recording *Subscription
+	tracer    *Tracer
+}
+
+type Point struct {
+	Time  int     `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// Series is the values of one node metric over a run, by simulated time in
+// milliseconds.
+type Series struct {
+	NodeID string  `json:"nodeId"`
+	Metric string  `json:"metric"`
+	Unit   string  `json:"unit"`
+	Points []Point `json:"points"`
+}
+
+func NewRun(design SystemRecord) *Run {
+	run := &Run{
+		ID:        shortuuid.New(),
+		StartedAt: time.Now().UTC(),
+		series:    map[string]*Series{},
+	}
+	run.tracer = NewTracer(run.ID)
+	run.record = RunRecord{
+		ID:        run.ID,
+		SystemID:  design.ID,
+		State:     RunningState,
+		StartedAt: run.StartedAt,
+		Seed:      design.Seed,
+		Design:    design,
+	}
+	return run
+}
+
+func (r *Run) Record() RunRecord {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.record
+}
+
+// setSeed records the seed the run drew when the design left it unset.
+func (r *Run) setSeed(seed int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.record.Seed = seed
+}
+
+func (r *Run) end(state string, reason string, summary RunSummary) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	endedAt := time.Now().UTC()
+	r.record.State = state
+	r.record.Reason = reason
+	r.record.EndedAt = &endedAt
+	r.record.Summary = &summary
+}
+
+// collect stores the metrics messages of the subscription until it ends.
+func (r *Run) collect(sub *Subscription) {
+	for msg := range sub.Messages() {
+		if msg.Type == MetricsMessage {
+			r.add(msg)
+		}
+	}
+}
+
+func (r *Run) add(msg Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, metric := range msg.Metrics {
+		key := msg.NodeID + "/" + metric.Name
+		series, ok := r.series[key]
+		if !ok {
+			series = &Series{
+				NodeID: msg.NodeID,
+				Metric: metric.Name,
+				Unit:   metric.Unit,
+			}
+			r.series[key] = series
+		}
+
+		series.Points = append(series.Points, Point{Time: msg.Time, Value: float64(metric.Value)})
+		if len(series.Points) > MaxSeriesPoints {
+			series.Points = series.Points[len(series.Points)-MaxSeriesPoints:]
+		}
+	}
+}
+
+// SeriesQuery picks the series of a run to return. Empty Nodes or Metrics
+// match every node or metric. From and To bound the simulated time in
+// milliseconds, a zero To meaning the end of the run, and points are
+// averaged over buckets of Step milliseconds. A zero Step picks one that
+// keeps each series within MaxQueryPoints.
+type SeriesQuery struct {
+	Nodes   []string
+	Metrics []string
+	From    int
+	To      int
+	Step    int
+}
+
+type SeriesResult struct {
+	RunID  string   `json:"runId"`
+	From   int      `json:"from"`
+	To     int      `json:"to"`
+	Step   int      `json:"step"`
+	Series []Series `json:"series"`
+}
+
+func (q SeriesQuery) Validate() error {
+	if q.From < 0 || q.To < 0 || q.Step < 0 {
+		return errors.New("from, to and step cannot be negative")
+	}
+	if q.To != 0 && q.To < q.From {
+		return fmt.Errorf("to %d is before from %d", q.To, q.From)
+	}
+	return nil
+}
+
+// Query returns the downsampled series matching the query, ordered by node
+// and metric.
+func (r *Run) Query(query SeriesQuery) SeriesResult {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	nodes := map[string]bool{}
+	for _, nodeID := range query.Nodes {
+		nodes[nodeID] = true
+	}
+	metrics := map[string]bool{}
+	for _, name := range query.Metrics {
+		metrics[name] = true
+	}
+
+	matched := []*Series{}
+	end := 0
+	for _, series := range r.series {
+		if len(nodes) > 0 && !nodes[series.NodeID] {
+			continue
+		}
+		if len(metrics) > 0 && !metrics[series.Metric] {
+			continue
+		}
+		matched = append(matched, series)
+		if len(series.Points) > 0 && series.Points[len(series.Points)-1].Time > end {
+			end = series.Points[len(series.Points)-1].Time
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].NodeID == matched[j].NodeID {
+			return matched[i].Metric < matched[j].Metric
+		}
+		return matched[i].NodeID < matched[j].NodeID
+	})
+
+	result := SeriesResult{
+		RunID:  r.ID,
+		From:   query.From,
+		To:     query.To,
+		Step:   query.Step,
+		Series: []Series{},
+	}
+	if result.To == 0 {
+		result.To = end
+	}
+	if result.Step == 0 {
+		// Round up to whole metric intervals, the resolution of the samples
+		interval := int(MetricsInterval.Milliseconds())
+		span := result.To - result.From
+		result.Step = int(math.Ceil(float64(span)/float64(MaxQueryPoints*interval))) * interval
+		if result.Step < interval {
+			result.Step = interval
+		}
+	}
+
+	for _, series := range matched {
+		result.Series = append(result.Series, Series{
+			NodeID: series.NodeID,
+			Metric: series.Metric,
+			Unit:   series.Unit,
+			Points: downsample(series.Points, result.From, result.To, result.Step),
+		})
+	}
+	return result
+}
+
+// downsample averages the points between from and to over buckets of step
+// milliseconds, each point timed at the start of its bucket.
+func downsample(points []Point, from int, to int, step int) []Point {
+	downsampled := []Point{}
+	count := 0
+	for _, point := range points {
+		if point.Time < from || point.Time > to {
+			continue
+		}
+
+		bucket := from + (point.Time-from)/step*step
+		last := len(downsampled) - 1
+		if last >= 0 && downsampled[last].Time == bucket {
+			count++
+			downsampled[last].Value += (point.Value - downsampled[last].Value) / float64(count)
+			continue
+		}
+		downsampled = append(downsampled, Point{Time: bucket, Value: point.Value})
+		count = 1
+	}
+
+	for i := range downsampled {
+		downsampled[i].Value = math.Round(downsampled[i].Value*100) / 100
+	}
+	return downsampled
+}
+
+// beginRun ends the current run, if any, and starts recording a new one.
+// Callers must hold the mutex, and pass the ended run, if any, to
+// saveRun once they release it.
+func (s *System) beginRun() *Run {
+	ended := s.stopRecording(StoppedState, "restarted")
+
+	run := NewRun(s.record())
+	run.recording = s.hub.Listen(RecorderBuffer)
+	go run.collect(run.recording)
+
+	s.runs = append(s.runs, run)
+	if len(s.runs) > MaxRuns {
+		s.runs = s.runs[len(s.runs)-MaxRuns:]
+	}
+	s.run = run
+	return ended
+}
+
+// stopRecording ends the current run, if any, and returns it. The nodes
+// must have stopped so that the summary is final. Callers must hold the
+// mutex.
+func (s *System) stopRecording(state string, reason string) *Run {
+	ended := s.run
+	if ended == nil {
+		return nil
+	}
+
+	ended.end(state, reason, s.summarize())
+	s.hub.Unsubscribe(ended.recording)
+	s.run = nil
+	return ended
+}
+
+// saveRun hands an ended run to the store, if the system is in one.
+func (s *System) saveRun(run *Run) {
+	if s.runEnded != nil {
+		s.runEnded(run)
+	}
+}
+
+// FindRun returns one of the system's recent runs.
+func (s *System) FindRun(runID string) (*Run, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, run := range s.runs {
+		if run.ID == runID {
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+}
+
+// saveRun stores the record of a run that ended and exports its traces. It
+// only logs failures, as the run has already ended by then.
+func (ss *SystemStore) saveRun(run *Run) {
+	record := run.Record()
+
+	ss.mutex.Lock()
+	err := ss.storage.SaveRun(record)
+	ss.mutex.Unlock()
+	if err != nil {
+		log.Printf("run %s of system %s could not be saved: %v", record.ID, record.SystemID, err)
+	}
+
+	if ss.exporter != nil {
+		go ss.exporter.Export(run)
+	}
+}
+
+// Runs returns the records of the system's runs, newest first, including
+// the one in progress.
+func (ss *SystemStore) Runs(system *System) ([]RunRecord, error) {
+	records, err := ss.storage.LoadRuns(system.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	system.mutex.RLock()
+	if system.run != nil {
+		records = append(records, system.run.Record())
+	}
+	system.mutex.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.After(records[j].StartedAt)
+	})
+	return records, nil
+}
+
+func (ss *SystemStore) Run(system *System, runID string) (RunRecord, error) {
+	records, err := ss.Runs(system)
+	if err != nil {
+		return RunRecord{}, err
+	}
+
+	for _, record := range records {
+		if record.ID == runID {
+			return record, nil
+		}
+	}
+	return RunRecord{}, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+}
+
+// FindRunRecord returns the record of a run of any system.
+func (ss *SystemStore) FindRunRecord(ref RunRef) (RunRecord, error) {
+	system, err := ss.Get(ref.SystemID)
+	if err != nil {
+		return RunRecord{}, err
+	}
+	return ss.Run(system, ref.RunID)
+}
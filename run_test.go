@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDownsample(t *testing.T) {
+	points := []Point{{0, 1}, {100, 2}, {200, 3}, {300, 4}, {400, 5}}
+
+	tests := []struct {
+		name     string
+		points   []Point
+		from     int
+		to       int
+		step     int
+		expected []Point
+	}{
+		{"every point", points, 0, 400, 100, points},
+		{"pairs", points, 0, 400, 200, []Point{{0, 1.5}, {200, 3.5}, {400, 5}}},
+		{"window", points, 100, 300, 100, []Point{{100, 2}, {200, 3}, {300, 4}}},
+		{"buckets from the window start", points, 100, 400, 200, []Point{{100, 2.5}, {300, 4.5}}},
+		{"step past the range", points, 0, 400, 10000, []Point{{0, 3}}},
+		{"rounded", []Point{{0, 1}, {100, 1}, {200, 2}}, 0, 200, 300, []Point{{0, 1.33}}},
+		{"window past the end", points, 500, 900, 100, []Point{}},
+		{"empty", []Point{}, 0, 400, 100, []Point{}},
+	}
+	for _, test := range tests {
+		downsampled := downsample(test.points, test.from, test.to, test.step)
+		if !reflect.DeepEqual(downsampled, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, downsampled, test.expected)
+		}
+	}
+}
+
+// newTestRun records a run in which two nodes publish two metrics every
+// metrics interval for the given number of samples.
+func newTestRun(samples int) *Run {
+	run := NewRun(SystemRecord{ID: "system"})
+	interval := int(MetricsInterval.Milliseconds())
+	for i := 0; i < samples; i++ {
+		for _, nodeID := range []string{"b", "a"} {
+			run.add(Message{
+				Type:    MetricsMessage,
+				NodeID:  nodeID,
+				Time:    i * interval,
+				Metrics: []Metric{NewQueued(i), NewUtilisation(50)},
+			})
+		}
+	}
+	return run
+}
+
+func TestQuery(t *testing.T) {
+	run := newTestRun(1000)
+
+	tests := []struct {
+		name   string
+		query  SeriesQuery
+		series []string
+		from   int
+		to     int
+		step   int
+		points int
+	}{
+		{"everything", SeriesQuery{}, []string{"a/Queued", "a/Utilisation", "b/Queued", "b/Utilisation"}, 0, 99900, 200, 500},
+		{"node", SeriesQuery{Nodes: []string{"b"}}, []string{"b/Queued", "b/Utilisation"}, 0, 99900, 200, 500},
+		{"metric", SeriesQuery{Metrics: []string{"Queued"}}, []string{"a/Queued", "b/Queued"}, 0, 99900, 200, 500},
+		{"node and metric", SeriesQuery{Nodes: []string{"a"}, Metrics: []string{"Utilisation"}}, []string{"a/Utilisation"}, 0, 99900, 200, 500},
+		{"window", SeriesQuery{Nodes: []string{"a"}, Metrics: []string{"Queued"}, From: 1000, To: 2000}, []string{"a/Queued"}, 1000, 2000, 100, 11},
+		{"step", SeriesQuery{Nodes: []string{"a"}, Metrics: []string{"Queued"}, Step: 1000}, []string{"a/Queued"}, 0, 99900, 1000, 100},
+		{"step past the range", SeriesQuery{Nodes: []string{"a"}, Metrics: []string{"Queued"}, From: 1000, To: 2000, Step: 5000}, []string{"a/Queued"}, 1000, 2000, 5000, 1},
+		{"unknown node", SeriesQuery{Nodes: []string{"c"}}, []string{}, 0, 0, 100, 0},
+	}
+	for _, test := range tests {
+		result := run.Query(test.query)
+		names := []string{}
+		for _, series := range result.Series {
+			names = append(names, series.NodeID+"/"+series.Metric)
+			if len(series.Points) != test.points {
+				t.Errorf("%s: %s has %d points, expected %d", test.name, series.Metric, len(series.Points), test.points)
+			}
+		}
+		if !reflect.DeepEqual(names, test.series) {
+			t.Errorf("%s: got series %v, expected %v", test.name, names, test.series)
+		}
+		if result.From != test.from || result.To != test.to || result.Step != test.step {
+			t.Errorf("%s: got from %d to %d step %d, expected %d to %d step %d", test.name, result.From, result.To, result.Step, test.from, test.to, test.step)
+		}
+	}
+}
+
+// TestQueryMaxPoints drops the oldest samples past MaxSeriesPoints, and
+// keeps what is left within MaxQueryPoints when no step is given.
+func TestQueryMaxPoints(t *testing.T) {
+	run := newTestRun(MaxSeriesPoints + 100)
+
+	kept := run.series["a/Queued"].Points
+	if len(kept) != MaxSeriesPoints || kept[0].Value != 100 {
+		t.Errorf("kept %d samples starting with %v", len(kept), kept[0])
+	}
+
+	result := run.Query(SeriesQuery{Nodes: []string{"a"}, Metrics: []string{"Queued"}})
+	points := result.Series[0].Points
+	if len(points) > MaxQueryPoints {
+		t.Errorf("returned %d points, at most %d expected", len(points), MaxQueryPoints)
+	}
+}
+
+func TestQueryEmptyRun(t *testing.T) {
+	run := NewRun(SystemRecord{ID: "system"})
+
+	result := run.Query(SeriesQuery{})
+	if len(result.Series) != 0 || result.To != 0 || result.Step != int(MetricsInterval.Milliseconds()) {
+		t.Errorf("got %+v", result)
+	}
+}
+
+func TestSeriesQueryValidate(t *testing.T) {
+	tests := []struct {
+		query SeriesQuery
+		valid bool
+	}{
+		{SeriesQuery{}, true},
+		{SeriesQuery{From: 100, To: 100}, true},
+		{SeriesQuery{From: 100}, true},
+		{SeriesQuery{From: 200, To: 100}, false},
+		{SeriesQuery{From: -1}, false},
+		{SeriesQuery{Step: -1}, false},
+	}
+	for _, test := range tests {
+		err := test.query.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("validating %+v returned %v", test.query, err)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"github.com/lithammer/shortuuid/v3"
+	"fmt"
 	"log"
 	"math/rand"
 	"sync"
@@ -14,6 +14,15 @@ const (
 	MaxRoutines         = 20
 	ProcessingTimeLower = 300
 	ProcessingTimeUpper = 600
+
+	DatabaseMaxRoutines         = 50
+	DatabaseProcessingTimeLower = 20
+	DatabaseProcessingTimeUpper = 80
+
+	CacheMaxRoutines         = 100
+	CacheProcessingTimeLower = 1
+	CacheProcessingTimeUpper = 5
+	CacheHitRate             = 80
 )
 
 type Server struct {
@@ -21,22 +30,78 @@ type Server struct {
 	Type     string
 	Position Position
 
-	InRequests  chan Request
-	InResponses chan Response
-	routines    chan int
+	Sources []Source `json:"-"`
+	Targets []Target `json:"-"`
+	waiters *ResponseWaiters
+
+	// Requests beyond maxRoutines wait in pending until a routine is free
+	maxRoutines int
+	running     int
+	pending     []pendingRequest
+
+	config NodeConfig
+	fault  Fault
+	seed   int64
+	mutex  sync.Mutex
 
-	messages     chan Message
+	hub          *Hub
+	clock        *Clock
 	numProcessed int64
+	numFailed    int64
+	nextTarget   int64
+	latencies    *Histogram
+	waits        *Histogram
+	load         *Load
+	tracer       *Tracer
 }
 
-func NewServer(messages chan Message) *Server {
-	routines := make(chan int, MaxRoutines)
+func NewServer(id string, hub *Hub, clock *Clock) *Server {
 	return &Server{
-		ID:       shortuuid.New(),
-		Type:     ServerType,
-		routines: routines,
-		messages: messages,
+		ID:          id,
+		Type:        ServerType,
+		maxRoutines: MaxRoutines,
+		config: NodeConfig{
+			MaxRoutines:         MaxRoutines,
+			ProcessingTimeLower: ProcessingTimeLower,
+			ProcessingTimeUpper: ProcessingTimeUpper,
+		},
+		seed:      time.Now().UnixNano(),
+		waiters:   NewResponseWaiters(),
+		latencies: NewHistogram(),
+		waits:     NewHistogram(),
+		load:      NewLoad(),
+		hub:       hub,
+		clock:     clock,
+	}
+}
+
+// NewDatabase returns a server with the faster, wider defaults of a
+// database. Like any server it accepts connections from several callers.
+func NewDatabase(id string, hub *Hub, clock *Clock) *Server {
+	database := NewServer(id, hub, clock)
+	database.Type = DatabaseType
+	database.maxRoutines = DatabaseMaxRoutines
+	database.config = NodeConfig{
+		MaxRoutines:         DatabaseMaxRoutines,
+		ProcessingTimeLower: DatabaseProcessingTimeLower,
+		ProcessingTimeUpper: DatabaseProcessingTimeUpper,
 	}
+	return database
+}
+
+// NewCache returns a server that answers most requests itself and only calls
+// its downstream targets on a miss, as often as its hit rate leaves over.
+func NewCache(id string, hub *Hub, clock *Clock) *Server {
+	cache := NewServer(id, hub, clock)
+	cache.Type = CacheType
+	cache.maxRoutines = CacheMaxRoutines
+	cache.config = NodeConfig{
+		MaxRoutines:         CacheMaxRoutines,
+		ProcessingTimeLower: CacheProcessingTimeLower,
+		ProcessingTimeUpper: CacheProcessingTimeUpper,
+		HitRate:             CacheHitRate,
+	}
+	return cache
 }
 
 func (s *Server) GetID() string {
@@ -55,13 +120,80 @@ func (s *Server) SetPosition(pos Position) {
 	s.Position = pos
 }
 
+func (s *Server) GetConfig() NodeConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.config
+}
+
+func (s *Server) GetFault() Fault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.fault
+}
+
+func (s *Server) SetFault(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.fault = fault
+}
+
+// SetConfig applies the non-zero fields of config on top of the current one.
+// A running server picks up the new processing times and hit rate straight
+// away, and its routines with the next run.
+func (s *Server) SetConfig(config NodeConfig) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	merged := s.config
+	if config.MaxRoutines != 0 {
+		merged.MaxRoutines = config.MaxRoutines
+	}
+	if config.ProcessingTimeLower != 0 {
+		merged.ProcessingTimeLower = config.ProcessingTimeLower
+	}
+	if config.ProcessingTimeUpper != 0 {
+		merged.ProcessingTimeUpper = config.ProcessingTimeUpper
+	}
+	if config.HitRate != 0 {
+		merged.HitRate = config.HitRate
+	}
+
+	if merged.MaxRoutines < 1 {
+		return fmt.Errorf("%s maxRoutines must be at least 1", s.Type)
+	}
+	if merged.ProcessingTimeLower < 0 || merged.ProcessingTimeLower > merged.ProcessingTimeUpper {
+		return fmt.Errorf("%s processing time range %d-%dms is invalid", s.Type, merged.ProcessingTimeLower, merged.ProcessingTimeUpper)
+	}
+	if merged.HitRate < 0 || merged.HitRate > 100 {
+		return fmt.Errorf("%s hitRate must be between 0 and 100", s.Type)
+	}
+
+	s.config = merged
+	return nil
+}
+
 func (s *Server) SetInChannels(inRequests chan Request, inResponses chan Response) {
-	s.InRequests = inRequests
-	s.InResponses = inResponses
+	s.Sources = append(s.Sources, Source{
+		InRequests:  inRequests,
+		InResponses: inResponses,
+	})
+}
+
+// SetOutChannels connects the server to a downstream dependency, such as a
+// database, that it calls after processing each request.
+func (s *Server) SetOutChannels(outRequests chan Request, outResponses chan Response) {
+	s.Targets = append(s.Targets, Target{
+		OutRequests:  outRequests,
+		OutResponses: outResponses,
+	})
 }
 
 func (s *Server) Run(ctx context.Context, wg *sync.WaitGroup, _ context.CancelFunc) {
-	metricsTicker := time.NewTicker(100 * time.Millisecond)
+	metricsTicker := s.clock.NewTicker(ctx, MetricsInterval)
 
 	wg.Add(1)
 	go func() {
@@ -70,63 +202,294 @@ func (s *Server) Run(ctx context.Context, wg *sync.WaitGroup, _ context.CancelFu
 		for {
 			select {
 			case <-ctx.Done():
-				log.Printf("%s recieving goroutine cancelled", s.Type)
+				log.Printf("%s metrics goroutine cancelled", s.Type)
 				return
-			case request := <-s.InRequests:
-				s.routines <- 1
-
-				wg.Add(1)
-				go s.Process(ctx, wg, request)
 			case _ = <-metricsTicker.C:
-				utilization := int(float64(len(s.routines)) / float64(MaxRoutines) * 100)
-				log.Printf("%s sending metrics: Processed = %d, Queued = %d, Utilisation = %v", s.Type, int(s.numProcessed), len(s.InRequests), utilization)
-
-				s.messages <- Message{
-					NodeID: s.ID,
-					Metrics: []Metric{
-						NewProcessed(int(s.numProcessed)),
-						NewQueued(len(s.InRequests)),
-						NewUtilisation(utilization),
-					},
-				}
+				metrics := s.GetMetrics()
+				log.Printf("%s sending metrics: Processed = %d, Queued = %d, Utilisation = %v", s.Type, metrics[0].Value, metrics[1].Value, metrics[2].Value)
+				s.load.Sample(metrics[2].Value, metrics[1].Value)
+
+				msg := NewMetricsMessage(s.ID, s.clock.Elapsed(), metrics)
+				s.hub.Publish(msg)
+				s.clock.Release()
 			}
 		}
 	}()
+
+	for i, source := range s.Sources {
+		wg.Add(1)
+		go func(sourceNumber int, source Source) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					log.Printf("%s source #%d goroutine cancelled", s.Type, sourceNumber)
+					return
+				case request := <-source.InRequests:
+					s.admit(ctx, wg, request, source.InResponses)
+				}
+			}
+		}(i, source)
+	}
+
+	for i, target := range s.Targets {
+		wg.Add(1)
+		go func(targetNumber int, target Target) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					log.Printf("%s target #%d goroutine cancelled", s.Type, targetNumber)
+					return
+				case response := <-target.OutResponses:
+					if !s.waiters.Deliver(response) {
+						s.clock.Release()
+					}
+				}
+			}
+		}(i, target)
+	}
 }
 
-func (s *Server) Process(ctx context.Context, wg *sync.WaitGroup, request Request) {
-	defer func() {
-		<-s.routines
-		wg.Done()
-	}()
+// pendingRequest is a request waiting for one of the server's routines.
+type pendingRequest struct {
+	request   Request
+	responses chan Response
+}
+
+// admit starts processing the request if a routine is free and queues it
+// otherwise, giving up its hold on the clock until a routine takes it.
+func (s *Server) admit(ctx context.Context, wg *sync.WaitGroup, request Request, responses chan Response) {
+	s.mutex.Lock()
+	if s.running >= s.maxRoutines {
+		s.pending = append(s.pending, pendingRequest{request: request, responses: responses})
+		s.mutex.Unlock()
+		s.clock.Release()
+		return
+	}
+	s.running++
+	s.mutex.Unlock()
+
+	wg.Add(1)
+	go s.Process(ctx, wg, request, responses)
+}
 
+// Process runs a routine, which processes the request and then every
+// pending one until none are left.
+func (s *Server) Process(ctx context.Context, wg *sync.WaitGroup, request Request, responses chan Response) {
+	defer wg.Done()
+
+	for s.process(ctx, request, responses) {
+		s.mutex.Lock()
+		if len(s.pending) == 0 {
+			s.running--
+			s.mutex.Unlock()
+			s.clock.Release()
+			return
+		}
+		next := s.pending[0]
+		s.pending = s.pending[1:]
+		s.mutex.Unlock()
+
+		request, responses = next.request, next.responses
+	}
+}
+
+// process handles a request, reporting whether it was done before the run
+// was cancelled.
+func (s *Server) process(ctx context.Context, request Request, responses chan Response) bool {
+	err := s.clock.Acquire(ctx)
+	if err != nil {
+		log.Printf("%s processing request number %d cancelled", s.Type, request.ID)
+		return false
+	}
+
+	startedAt := s.clock.Now()
+	s.waits.Record(startedAt.Sub(request.EnqueuedAt).Milliseconds())
+	request = s.tracer.Start(s, request, startedAt)
+	log.Printf("%s receieved request number %d", s.Type, request.ID)
+	s.clock.Record(NewEvent(s, ProcessingStartedEvent, request.ID, fmt.Sprintf("%s started processing request %d", s.Type, request.ID)))
+
+	// Generate a random duration within the configured processing time range.
+	// A down node fails the request without processing it. Each request draws
+	// from its own stream, so that a seeded run does not depend on the order
+	// in which simultaneous requests are picked up.
+	s.mutex.Lock()
+	random := rand.New(rand.NewSource(NodeSeed(s.seed, s.ID+"/"+request.Key())))
+	processingTime := time.Duration(random.Intn(s.config.ProcessingTimeUpper-s.config.ProcessingTimeLower+1)+s.config.ProcessingTimeLower) * time.Millisecond
+	hit := random.Intn(100) < s.config.HitRate
+	fault := s.fault
+	failed := fault.Down
+	if !failed && fault.ErrorRate > 0 {
+		failed = random.Intn(100) < fault.ErrorRate
+	}
+	s.mutex.Unlock()
+	if fault.Down {
+		processingTime = 0
+	} else {
+		processingTime += time.Duration(fault.Latency) * time.Millisecond
+		err = s.clock.Sleep(ctx, processingTime)
+	}
+	var downstream time.Duration
+	if err == nil && !failed && !hit && len(s.Targets) > 0 {
+		failed, downstream, err = s.callTarget(ctx, request)
+	}
+	if err == nil {
+		err = s.clock.Acquire(ctx)
+	}
+	if err != nil {
+		log.Printf("%s processing request number %d cancelled", s.Type, request.ID)
+		return false
+	}
+
+	log.Printf("%s responding to request number %d", s.Type, request.ID)
+	s.tracer.End(s, request.TraceID, s.clock.Now(), processingTime, failed)
+	s.clock.Hold()
 	select {
+	case responses <- Response{ID: request.ID, Origin: request.Origin, Failed: failed, TraceID: request.TraceID}:
 	case <-ctx.Done():
 		log.Printf("%s processing request number %d cancelled", s.Type, request.ID)
-		return
-	default:
-		log.Printf("%s receieved request number %d", s.Type, request.ID)
+		return false
+	}
+	atomic.AddInt64(&s.numProcessed, 1)
+	// Time spent waiting on the target belongs to the target, not this node
+	s.latencies.Record((s.clock.Now().Sub(startedAt) - downstream).Milliseconds())
+	if failed {
+		atomic.AddInt64(&s.numFailed, 1)
+		s.clock.Record(NewEvent(s, ProcessingFailedEvent, request.ID, fmt.Sprintf("%s failed request %d after %dms", s.Type, request.ID, processingTime.Milliseconds())))
+	} else {
+		s.clock.Record(NewEvent(s, ProcessingCompletedEvent, request.ID, fmt.Sprintf("%s responded to request %d after %dms", s.Type, request.ID, processingTime.Milliseconds())))
+	}
+	return true
+}
+
+// callTarget forwards the request to the next downstream target, round
+// robin, and waits for its response. It reports whether the target failed
+// the request and how long the response took. The clock is given up while
+// waiting and held again by the response.
+func (s *Server) callTarget(ctx context.Context, request Request) (bool, time.Duration, error) {
+	err := s.clock.Acquire(ctx)
+	if err != nil {
+		return false, 0, err
+	}
 
-		rand.Seed(time.Now().UnixNano())
-		// Generate a random duration between 200 and 500 milliseconds
-		processingTime := time.Duration(rand.Intn(ProcessingTimeUpper-ProcessingTimeLower+1)+ProcessingTimeLower) * time.Millisecond
-		time.Sleep(processingTime)
+	responses := s.waiters.Add(request)
+	defer s.waiters.Remove(request)
 
-		log.Printf("%s responding to request number %d", s.Type, request.ID)
-		s.InResponses <- Response{ID: request.ID}
-		atomic.AddInt64(&s.numProcessed, 1)
+	targetNumber := int(atomic.AddInt64(&s.nextTarget, 1)-1) % len(s.Targets)
+	log.Printf("%s forwarding request %d to target #%d", s.Type, request.ID, targetNumber)
+	request.EnqueuedAt = s.clock.Now()
+	s.clock.Hold()
+	select {
+	case s.Targets[targetNumber].OutRequests <- request:
+	case <-ctx.Done():
+		return false, 0, ctx.Err()
+	}
+	s.clock.Record(NewEvent(s, RequestForwardedEvent, request.ID, fmt.Sprintf("%s forwarded request %d to target #%d", s.Type, request.ID, targetNumber)))
+	s.clock.Release()
+
+	select {
+	case <-ctx.Done():
+		return false, 0, ctx.Err()
+	case response := <-responses:
+		return response.Failed, s.clock.Now().Sub(request.EnqueuedAt), nil
 	}
 }
 
-func (s *Server) Reset() {
-	s.routines = make(chan int, MaxRoutines)
-	s.numProcessed = 0
+func (s *Server) Reset(run RunConfig) {
+	s.Sources = []Source{}
+	s.Targets = []Target{}
+	s.mutex.Lock()
+	s.maxRoutines = s.config.MaxRoutines
+	s.running = 0
+	s.pending = nil
+	s.mutex.Unlock()
+	s.seed = run.Seed
+	s.waiters = NewResponseWaiters()
+	atomic.StoreInt64(&s.numProcessed, 0)
+	atomic.StoreInt64(&s.numFailed, 0)
+	atomic.StoreInt64(&s.nextTarget, 0)
+	s.latencies = NewHistogram()
+	s.waits = NewHistogram()
+	s.load = NewLoad()
+	s.tracer = run.Tracer
 }
 
 func (s *Server) GetMetrics() []Metric {
-	return []Metric{
-		NewProcessed(0),
-		NewQueued(0),
-		NewUtilisation(0),
+	s.mutex.Lock()
+	queued := len(s.pending)
+	utilization := int(float64(s.running) / float64(s.maxRoutines) * 100)
+	s.mutex.Unlock()
+	for _, source := range s.Sources {
+		queued += len(source.InRequests)
+	}
+
+	metrics := []Metric{
+		NewProcessed(int(atomic.LoadInt64(&s.numProcessed))),
+		NewQueued(queued),
+		NewUtilisation(utilization),
+	}
+	return append(metrics, NewQueueingMetrics(s.waits, s.latencies)...)
+}
+
+// Latencies returns the processing times the server measured in the current
+// run.
+func (s *Server) Latencies() *Histogram {
+	return s.latencies
+}
+
+// Waits returns how long requests queued for the server in the current run.
+func (s *Server) Waits() *Histogram {
+	return s.waits
+}
+
+func (s *Server) Summary(elapsed time.Duration) NodeSummary {
+	summary := NewNodeSummary(s, elapsed, atomic.LoadInt64(&s.numProcessed), atomic.LoadInt64(&s.numFailed), s.latencies)
+	load := s.load.Summary()
+	summary.Load = &load
+	wait := s.waits.Summary()
+	summary.Wait = &wait
+	return summary
+}
+
+// ResponseWaiters routes responses from downstream targets back to the
+// request that is waiting on them.
+type ResponseWaiters struct {
+	m     map[string]chan Response
+	mutex sync.Mutex
+}
+
+func NewResponseWaiters() *ResponseWaiters {
+	return &ResponseWaiters{
+		m: map[string]chan Response{},
+	}
+}
+
+func (w *ResponseWaiters) Add(request Request) chan Response {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	responses := make(chan Response, 1)
+	w.m[request.Key()] = responses
+	return responses
+}
+
+func (w *ResponseWaiters) Remove(request Request) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.m, request.Key())
+}
+
+// Deliver hands the response to the request waiting on it, reporting
+// whether one was.
+func (w *ResponseWaiters) Deliver(response Response) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	responses, ok := w.m[response.Key()]
+	if !ok {
+		return false
 	}
+	responses <- response
+	return true
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConfigureWhileRunning changes a server's configuration while it
+// processes requests. Run it with -race.
+func TestConfigureWhileRunning(t *testing.T) {
+	system, err := CompileDSL("client -> server", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+	err = system.SetWorkload(Workload{NumRequests: 200, RequestInterval: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = system.SetSpeed(MaxSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var server Node
+	for _, node := range system.Record().Nodes {
+		if node.Type == ServerType {
+			server = system.nodeStore[node.ID]
+		}
+	}
+
+	err = system.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				err := server.SetConfig(NodeConfig{ProcessingTimeLower: 1 + i, ProcessingTimeUpper: 10 + j})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				server.GetConfig()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestServiceTimeLeavesOutTargets measures a server's service time without
+// the time its database took to answer.
+func TestServiceTimeLeavesOutTargets(t *testing.T) {
+	system, err := CompileDSL("client -> server {processingTimeLower: 10, processingTimeUpper: 10} -> db {processingTimeLower: 50, processingTimeUpper: 50}", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+	err = system.SetWorkload(Workload{NumRequests: 20, RequestInterval: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := runToEnd(t, system, MaxSpeed)
+	for _, node := range summary.Nodes {
+		if node.Type != ServerType {
+			continue
+		}
+		if node.Latency == nil || node.Latency.Max > 12 {
+			t.Errorf("server service time is %+v, expected about 10ms", node.Latency)
+		}
+	}
+}
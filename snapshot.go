@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lithammer/shortuuid/v3"
+	"sort"
+	"time"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is a named copy of a system's design at one point in time.
+type Snapshot struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Record    SystemRecord `json:"record"`
+}
+
+// SaveSnapshot stores the current design of the system under name.
+func (ss *SystemStore) SaveSnapshot(system *System, name string) (Snapshot, error) {
+	snapshot := Snapshot{
+		ID:        shortuuid.New(),
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+		Record:    system.Record(),
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	return snapshot, ss.storage.SaveSnapshot(system.ID, snapshot)
+}
+
+// Snapshots returns the snapshots of a system, oldest first.
+func (ss *SystemStore) Snapshots(systemID string) ([]Snapshot, error) {
+	snapshots, err := ss.storage.LoadSnapshots(systemID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+func (ss *SystemStore) Snapshot(systemID string, snapshotID string) (Snapshot, error) {
+	snapshots, err := ss.storage.LoadSnapshots(systemID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.ID == snapshotID {
+			return snapshot, nil
+		}
+	}
+	return Snapshot{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, snapshotID)
+}
+
+// RestoreSnapshot replaces the design with the snapshot's. Like any other
+// edit it can be undone, and like undo it needs the system to be stopped.
+func (s *System) RestoreSnapshot(snapshot Snapshot) (HistoryStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.expectStopped()
+	if err != nil {
+		return HistoryStatus{}, err
+	}
+
+	before := s.record()
+	s.restore(snapshot.Record)
+	s.commit("restore "+snapshot.Name, before)
+
+	return s.history.Status(), nil
+}
+
+// Diff is the structural difference between two designs. Edges are compared
+// by the nodes they connect, so rewiring shows up as one edge removed and
+// another added.
+type Diff struct {
+	NodesAdded   []NodeRecord    `json:"nodesAdded"`
+	NodesRemoved []NodeRecord    `json:"nodesRemoved"`
+	NodesChanged []NodeChange    `json:"nodesChanged"`
+	EdgesAdded   []EdgeRecord    `json:"edgesAdded"`
+	EdgesRemoved []EdgeRecord    `json:"edgesRemoved"`
+	Workload     *WorkloadChange `json:"workload,omitempty"`
+	Seed         *SeedChange     `json:"seed,omitempty"`
+}
+
+// NodeChange describes a node present in both designs whose config or
+// position differ. Unchanged parts are left out.
+type NodeChange struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Config   *ConfigChange   `json:"config,omitempty"`
+	Position *PositionChange `json:"position,omitempty"`
+}
+
+type ConfigChange struct {
+	From NodeConfig `json:"from"`
+	To   NodeConfig `json:"to"`
+}
+
+type PositionChange struct {
+	From Position `json:"from"`
+	To   Position `json:"to"`
+}
+
+type WorkloadChange struct {
+	From Workload `json:"from"`
+	To   Workload `json:"to"`
+}
+
+type SeedChange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+func DiffRecords(from SystemRecord, to SystemRecord) Diff {
+	diff := Diff{
+		NodesAdded:   []NodeRecord{},
+		NodesRemoved: []NodeRecord{},
+		NodesChanged: []NodeChange{},
+		EdgesAdded:   []EdgeRecord{},
+		EdgesRemoved: []EdgeRecord{},
+	}
+
+	fromNodes := map[string]NodeRecord{}
+	for _, node := range from.Nodes {
+		fromNodes[node.ID] = node
+	}
+	toNodes := map[string]NodeRecord{}
+	for _, node := range to.Nodes {
+		toNodes[node.ID] = node
+	}
+
+	for _, node := range to.Nodes {
+		previous, ok := fromNodes[node.ID]
+		if !ok || previous.Type != node.Type {
+			diff.NodesAdded = append(diff.NodesAdded, node)
+			continue
+		}
+
+		change := NodeChange{ID: node.ID, Type: node.Type}
+		if previous.Config != node.Config {
+			change.Config = &ConfigChange{From: previous.Config, To: node.Config}
+		}
+		if previous.Position != node.Position {
+			change.Position = &PositionChange{From: previous.Position, To: node.Position}
+		}
+		if change.Config != nil || change.Position != nil {
+			diff.NodesChanged = append(diff.NodesChanged, change)
+		}
+	}
+	for _, node := range from.Nodes {
+		next, ok := toNodes[node.ID]
+		if !ok || next.Type != node.Type {
+			diff.NodesRemoved = append(diff.NodesRemoved, node)
+		}
+	}
+
+	connects := func(edges []EdgeRecord) map[[2]string]bool {
+		pairs := map[[2]string]bool{}
+		for _, edge := range edges {
+			pairs[[2]string{edge.Source, edge.Target}] = true
+		}
+		return pairs
+	}
+	fromEdges := connects(from.Edges)
+	toEdges := connects(to.Edges)
+	for _, edge := range to.Edges {
+		if !fromEdges[[2]string{edge.Source, edge.Target}] {
+			diff.EdgesAdded = append(diff.EdgesAdded, edge)
+		}
+	}
+	for _, edge := range from.Edges {
+		if !toEdges[[2]string{edge.Source, edge.Target}] {
+			diff.EdgesRemoved = append(diff.EdgesRemoved, edge)
+		}
+	}
+
+	if from.Workload != to.Workload {
+		diff.Workload = &WorkloadChange{From: from.Workload, To: to.Workload}
+	}
+	if from.Seed != to.Seed {
+		diff.Seed = &SeedChange{From: from.Seed, To: to.Seed}
+	}
+
+	return diff
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRecords(t *testing.T) {
+	from := SystemRecord{
+		Workload: Workload{NumRequests: 100, RequestInterval: 10},
+		Seed:     1,
+		Nodes: []NodeRecord{
+			{ID: "client", Type: ClientType},
+			{ID: "server", Type: ServerType, Config: NodeConfig{MaxRoutines: 2}},
+			{ID: "old", Type: ServerType},
+			{ID: "store", Type: DatabaseType, Position: Position{X: 1, Y: 1}},
+		},
+		Edges: []EdgeRecord{
+			{ID: "e1", Source: "client", Target: "server"},
+			{ID: "e2", Source: "server", Target: "store"},
+			{ID: "e3", Source: "client", Target: "old"},
+		},
+	}
+	to := SystemRecord{
+		Workload: Workload{NumRequests: 200, RequestInterval: 10},
+		Seed:     2,
+		Nodes: []NodeRecord{
+			{ID: "client", Type: ClientType},
+			{ID: "server", Type: ServerType, Config: NodeConfig{MaxRoutines: 8}},
+			{ID: "new", Type: CacheType},
+			{ID: "store", Type: DatabaseType, Position: Position{X: 5, Y: 1}},
+		},
+		Edges: []EdgeRecord{
+			// Same connection under a new ID, which is not a change
+			{ID: "e4", Source: "client", Target: "server"},
+			{ID: "e5", Source: "server", Target: "new"},
+			{ID: "e6", Source: "new", Target: "store"},
+		},
+	}
+
+	want := Diff{
+		NodesAdded:   []NodeRecord{to.Nodes[2]},
+		NodesRemoved: []NodeRecord{from.Nodes[2]},
+		NodesChanged: []NodeChange{
+			{
+				ID:     "server",
+				Type:   ServerType,
+				Config: &ConfigChange{From: NodeConfig{MaxRoutines: 2}, To: NodeConfig{MaxRoutines: 8}},
+			},
+			{
+				ID:       "store",
+				Type:     DatabaseType,
+				Position: &PositionChange{From: Position{X: 1, Y: 1}, To: Position{X: 5, Y: 1}},
+			},
+		},
+		EdgesAdded:   []EdgeRecord{to.Edges[1], to.Edges[2]},
+		EdgesRemoved: []EdgeRecord{from.Edges[1], from.Edges[2]},
+		Workload:     &WorkloadChange{From: from.Workload, To: to.Workload},
+		Seed:         &SeedChange{From: 1, To: 2},
+	}
+
+	diff := DiffRecords(from, to)
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("got %+v, expected %+v", diff, want)
+	}
+}
+
+func TestDiffRecordsUnchanged(t *testing.T) {
+	record := SystemRecord{
+		Nodes: []NodeRecord{{ID: "a", Type: ClientType}, {ID: "b", Type: ServerType}},
+		Edges: []EdgeRecord{{ID: "e", Source: "a", Target: "b"}},
+	}
+
+	diff := DiffRecords(record, record)
+	if len(diff.NodesAdded)+len(diff.NodesRemoved)+len(diff.NodesChanged)+len(diff.EdgesAdded)+len(diff.EdgesRemoved) != 0 || diff.Workload != nil || diff.Seed != nil {
+		t.Errorf("diffing a design with itself found %+v", diff)
+	}
+}
+
+// TestDiffRecordsTypeChange treats a node whose type changed under the same
+// ID as removed and added again.
+func TestDiffRecordsTypeChange(t *testing.T) {
+	from := SystemRecord{Nodes: []NodeRecord{{ID: "a", Type: ServerType}}}
+	to := SystemRecord{Nodes: []NodeRecord{{ID: "a", Type: CacheType}}}
+
+	diff := DiffRecords(from, to)
+	if !reflect.DeepEqual(diff.NodesAdded, to.Nodes) || !reflect.DeepEqual(diff.NodesRemoved, from.Nodes) || len(diff.NodesChanged) != 0 {
+		t.Errorf("got %+v", diff)
+	}
+}
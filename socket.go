@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrInvalidCommand = errors.New("invalid command")
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+const (
+	SubscribeCommand string = "subscribe"
+	StartCommand     string = "start"
+	StopCommand      string = "stop"
+	PauseCommand     string = "pause"
+	ResumeCommand    string = "resume"
+	StepCommand      string = "step"
+	SpeedCommand     string = "speed"
+	FaultCommand     string = "fault"
+)
+
+// Error codes sent back with a failed command.
+const (
+	BadRequestCode     string = "bad-request"
+	UnknownCommandCode string = "unknown-command"
+	NotFoundCode       string = "not-found"
+	InvalidStateCode   string = "invalid-state"
+	InvalidDesignCode  string = "invalid-design"
+	TooManyRunsCode    string = "too-many-runs"
+	InternalCode       string = "internal"
+)
+
+// SocketCommand is sent by websocket clients to control the simulation.
+// The optional ID is echoed back in the ack or error that answers it.
+type SocketCommand struct {
+	ID      string   `json:"id"`
+	Type    string   `json:"type"`
+	Speed   float64  `json:"speed"`
+	Nodes   []string `json:"nodes"`
+	Metrics []string `json:"metrics"`
+	NodeID  string   `json:"nodeId"`
+	Fault   Fault    `json:"fault"`
+}
+
+// SocketFilter narrows the messages sent to one connection to the chosen
+// nodes and metric names. An empty choice lets everything through. It is
+// safe for concurrent use.
+type SocketFilter struct {
+	mutex   sync.RWMutex
+	nodes   map[string]bool
+	metrics map[string]bool
+}
+
+func (f *SocketFilter) Set(nodes []string, metrics []string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.nodes = map[string]bool{}
+	for _, nodeID := range nodes {
+		f.nodes[nodeID] = true
+	}
+	f.metrics = map[string]bool{}
+	for _, name := range metrics {
+		f.metrics[name] = true
+	}
+}
+
+// Apply returns the part of the message the connection asked for, and
+// whether anything is left to send. Messages about the whole system always
+// pass.
+func (f *SocketFilter) Apply(msg Message) (Message, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if msg.NodeID != "" && len(f.nodes) > 0 && !f.nodes[msg.NodeID] {
+		return msg, false
+	}
+	if msg.Type != MetricsMessage || len(f.metrics) == 0 {
+		return msg, true
+	}
+
+	metrics := []Metric{}
+	for _, metric := range msg.Metrics {
+		if f.metrics[metric.Name] {
+			metrics = append(metrics, metric)
+		}
+	}
+	msg.Metrics = metrics
+	return msg, len(metrics) > 0
+}
+
+// handleSocketCommand carries out a command and returns the ack or error
+// that answers it.
+func handleSocketCommand(systemStore *SystemStore, system *System, filter *SocketFilter, command SocketCommand) Message {
+	ack := Message{
+		Type:    AckMessage,
+		ID:      command.ID,
+		Command: command.Type,
+	}
+
+	var err error
+	switch command.Type {
+	case SubscribeCommand:
+		filter.Set(command.Nodes, command.Metrics)
+		return ack
+	case StartCommand:
+		err = system.Start()
+	case StopCommand:
+		err = system.Stop()
+	case PauseCommand:
+		err = system.Pause()
+	case ResumeCommand:
+		err = system.Resume()
+	case StepCommand:
+		var event Event
+		event, err = system.Step()
+		ack.Event = &event
+	case SpeedCommand:
+		err = system.SetSpeed(command.Speed)
+		if err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+		} else {
+			err = systemStore.Save(system)
+		}
+	case FaultCommand:
+		err = system.SetFault(command.NodeID, command.Fault)
+		ack.NodeID = command.NodeID
+		ack.Fault = &command.Fault
+	default:
+		err = fmt.Errorf("%w %q", ErrUnknownCommand, command.Type)
+	}
+	if err != nil {
+		return NewSocketError(command, err)
+	}
+
+	status := system.Status()
+	ack.Status = &status
+	return ack
+}
+
+// NewSocketError answers a command that failed, with a code saying why.
+func NewSocketError(command SocketCommand, err error) Message {
+	msg := Message{
+		Type:    ErrorMessage,
+		ID:      command.ID,
+		Command: command.Type,
+		Code:    InternalCode,
+		Error:   err.Error(),
+	}
+
+	var validationError *ValidationError
+	switch {
+	case errors.As(err, &validationError):
+		msg.Code = InvalidDesignCode
+		msg.Validation = &validationError.Validation
+	case errors.Is(err, ErrInvalidCommand), errors.Is(err, ErrInvalidFault):
+		msg.Code = BadRequestCode
+	case errors.Is(err, ErrUnknownCommand):
+		msg.Code = UnknownCommandCode
+	case errors.Is(err, ErrNodeNotFound):
+		msg.Code = NotFoundCode
+	case errors.Is(err, ErrInvalidState):
+		msg.Code = InvalidStateCode
+	case errors.Is(err, ErrTooManyRuns):
+		msg.Code = TooManyRunsCode
+	}
+	return msg
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSocketCommands(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSystemStore(storage, Limits{}, nil)
+	system, err := CompileDSL("workload {numRequests: 1000, requestInterval: 100}\nclient -> server", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Add(system)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+	empty := NewSystem()
+	defer empty.Close()
+
+	tests := []struct {
+		system  *System
+		command SocketCommand
+		code    string
+		state   string
+	}{
+		{system, SocketCommand{ID: "1", Type: PauseCommand}, InvalidStateCode, ""},
+		{system, SocketCommand{ID: "2", Type: "dance"}, UnknownCommandCode, ""},
+		{system, SocketCommand{ID: "3", Type: SpeedCommand, Speed: 1000}, BadRequestCode, ""},
+		{system, SocketCommand{ID: "4", Type: FaultCommand, NodeID: "missing", Fault: Fault{Down: true}}, NotFoundCode, ""},
+		{empty, SocketCommand{ID: "5", Type: StartCommand}, InvalidDesignCode, ""},
+		{system, SocketCommand{ID: "6", Type: SubscribeCommand, Metrics: []string{"Queued"}}, "", ""},
+		{system, SocketCommand{ID: "7", Type: SpeedCommand, Speed: 2}, "", IdleState},
+		{system, SocketCommand{ID: "8", Type: StartCommand}, "", RunningState},
+		{system, SocketCommand{ID: "9", Type: PauseCommand}, "", PausedState},
+		{system, SocketCommand{ID: "10", Type: StopCommand}, "", IdleState},
+	}
+	for _, test := range tests {
+		msg := handleSocketCommand(store, test.system, &SocketFilter{}, test.command)
+		if msg.ID != test.command.ID || msg.Command != test.command.Type {
+			t.Errorf("%s answered as %q %s", test.command.Type, msg.ID, msg.Command)
+		}
+
+		if test.code != "" {
+			if msg.Type != ErrorMessage || msg.Code != test.code {
+				t.Errorf("%s returned %s %q, expected error %q: %s", test.command.Type, msg.Type, msg.Code, test.code, msg.Error)
+			}
+			if test.code == InvalidDesignCode && (msg.Validation == nil || msg.Validation.Valid) {
+				t.Errorf("%s did not return the validation", test.command.Type)
+			}
+			continue
+		}
+
+		if msg.Type != AckMessage {
+			t.Errorf("%s returned %s: %s", test.command.Type, msg.Type, msg.Error)
+			continue
+		}
+		if test.state != "" && (msg.Status == nil || msg.Status.State != test.state) {
+			t.Errorf("%s acked with status %+v, expected %s", test.command.Type, msg.Status, test.state)
+		}
+	}
+}
+
+func TestSocketFilter(t *testing.T) {
+	filter := &SocketFilter{}
+	filter.Set([]string{"a"}, []string{"Queued"})
+
+	metrics := Message{Type: MetricsMessage, NodeID: "a", Metrics: []Metric{NewQueued(1), NewUtilisation(50)}}
+	msg, ok := filter.Apply(metrics)
+	if !ok || len(msg.Metrics) != 1 || msg.Metrics[0].Name != "Queued" {
+		t.Errorf("filtered node a's metrics to %+v", msg.Metrics)
+	}
+	metrics.NodeID = "b"
+	if _, ok := filter.Apply(metrics); ok {
+		t.Error("passed the metrics of node b")
+	}
+	if _, ok := filter.Apply(Message{Type: StatusMessage}); !ok {
+		t.Error("held back a status message")
+	}
+}
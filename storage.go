@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrSystemNotFound = errors.New("system not found")
+
+// Storage persists system designs so that they survive restarts.
+type Storage interface {
+	Save(record SystemRecord) error
+	Load(id string) (SystemRecord, error)
+	Delete(id string) error
+	List() ([]SystemRecord, error)
+	SaveSnapshot(systemID string, snapshot Snapshot) error
+	LoadSnapshots(systemID string) ([]Snapshot, error)
+	SaveRun(record RunRecord) error
+	LoadRuns(systemID string) ([]RunRecord, error)
+}
+
+// SystemRecord is the persisted form of a system's design. Only the records
+// given to Storage carry the edit history.
+type SystemRecord struct {
+	ID       string         `json:"id"`
+	ParentID string         `json:"parentId,omitempty"`
+	Metadata Metadata       `json:"metadata"`
+	Speed    float64        `json:"speed"`
+	Workload Workload       `json:"workload"`
+	Seed     int64          `json:"seed"`
+	Nodes    []NodeRecord   `json:"nodes"`
+	Edges    []EdgeRecord   `json:"edges"`
+	History  *HistoryRecord `json:"history,omitempty"`
+}
+
+type NodeRecord struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	Position Position   `json:"position"`
+	Config   NodeConfig `json:"config"`
+}
+
+type EdgeRecord struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// FileStorage keeps one JSON document per system in a directory.
+type FileStorage struct {
+	dir string
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStorage{dir: dir}, nil
+}
+
+func (fs *FileStorage) Save(record SystemRecord) error {
+	return fs.write(fs.dir, record.ID, record)
+}
+
+func (fs *FileStorage) Load(id string) (SystemRecord, error) {
+	var record SystemRecord
+
+	recordBytes, err := os.ReadFile(fs.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return record, fmt.Errorf("%w: %s", ErrSystemNotFound, id)
+	} else if err != nil {
+		return record, err
+	}
+
+	err = json.Unmarshal(recordBytes, &record)
+	return record, err
+}
+
+// Delete removes the system along with its snapshots and runs.
+func (fs *FileStorage) Delete(id string) error {
+	err := os.Remove(fs.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrSystemNotFound, id)
+	} else if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(fs.snapshotDir(id))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(fs.runDir(id))
+}
+
+func (fs *FileStorage) List() ([]SystemRecord, error) {
+	records := []SystemRecord{}
+
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		record, err := fs.Load(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("system %s: %w", entry.Name(), err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// SaveSnapshot writes the snapshot to a directory kept for the system's
+// snapshots.
+func (fs *FileStorage) SaveSnapshot(systemID string, snapshot Snapshot) error {
+	dir := fs.snapshotDir(systemID)
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	return fs.write(dir, snapshot.ID, snapshot)
+}
+
+func (fs *FileStorage) LoadSnapshots(systemID string) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+
+	err := fs.readAll(fs.snapshotDir(systemID), func(name string, snapshotBytes []byte) error {
+		var snapshot Snapshot
+		err := json.Unmarshal(snapshotBytes, &snapshot)
+		if err != nil {
+			return fmt.Errorf("snapshot %s: %w", name, err)
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// SaveRun writes the run record to a directory kept for the system's runs,
+// and removes the oldest once there are more than MaxRuns.
+func (fs *FileStorage) SaveRun(record RunRecord) error {
+	dir := fs.runDir(record.SystemID)
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	err = fs.write(dir, record.ID, record)
+	if err != nil {
+		return err
+	}
+
+	records, err := fs.LoadRuns(record.SystemID)
+	if err != nil {
+		return err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.After(records[j].StartedAt)
+	})
+	for i := MaxRuns; i < len(records); i++ {
+		err = os.Remove(filepath.Join(dir, filepath.Base(records[i].ID)+".json"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *FileStorage) LoadRuns(systemID string) ([]RunRecord, error) {
+	records := []RunRecord{}
+
+	err := fs.readAll(fs.runDir(systemID), func(name string, recordBytes []byte) error {
+		var record RunRecord
+		err := json.Unmarshal(recordBytes, &record)
+		if err != nil {
+			return fmt.Errorf("run %s: %w", name, err)
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// readAll calls read with the contents of every JSON file in dir. A missing
+// dir has no files.
+func (fs *FileStorage) readAll(dir string, read func(name string, valueBytes []byte) error) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		valueBytes, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		err = read(entry.Name(), valueBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write stores value as id.json in dir.
+func (fs *FileStorage) write(dir string, id string, value interface{}) error {
+	valueBytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial design
+	tmp, err := os.CreateTemp(dir, filepath.Base(id)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(valueBytes)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, filepath.Base(id)+".json"))
+}
+
+func (fs *FileStorage) snapshotDir(systemID string) string {
+	return filepath.Join(fs.dir, "snapshots", filepath.Base(systemID))
+}
+
+func (fs *FileStorage) runDir(systemID string) string {
+	return filepath.Join(fs.dir, "runs", filepath.Base(systemID))
+}
+
+func (fs *FileStorage) path(id string) string {
+	// IDs come from request paths, so never let them escape the directory
+	return filepath.Join(fs.dir, filepath.Base(id)+".json")
+}
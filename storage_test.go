@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := SystemRecord{
+		ID:       "system",
+		Metadata: Metadata{Name: "shop", Tags: []string{"demo"}},
+		Speed:    2,
+		Workload: Workload{NumRequests: 10, RequestInterval: 5},
+		Seed:     42,
+		Nodes: []NodeRecord{
+			{ID: "a", Type: ClientType, Position: Position{X: 1, Y: 2}},
+			{ID: "b", Type: ServerType, Config: NodeConfig{MaxRoutines: 3}},
+		},
+		Edges: []EdgeRecord{{ID: "e", Source: "a", Target: "b"}},
+	}
+	err = storage.Save(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, record) {
+		t.Errorf("loaded %+v, saved %+v", loaded, record)
+	}
+
+	records, err := storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "system" {
+		t.Errorf("listed %+v", records)
+	}
+
+	err = storage.Delete("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.Load("system")
+	if !errors.Is(err, ErrSystemNotFound) {
+		t.Errorf("loading a deleted system returned %v", err)
+	}
+}
+
+// TestFileStorageWritesAtomically leaves only the finished document behind,
+// replacing the previous one whole.
+func TestFileStorageWritesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for seed := int64(1); seed <= 3; seed++ {
+		err = storage.Save(SystemRecord{ID: "system", Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "system.json" {
+		t.Errorf("left %v in the directory", entries)
+	}
+	record, err := storage.Load("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Seed != 3 {
+		t.Errorf("loaded seed %d, expected the last one saved", record.Seed)
+	}
+}
+
+// TestFileStorageStaysInDirectory keeps IDs taken from request paths from
+// reaching files outside the storage directory.
+func TestFileStorageStaysInDirectory(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "data")
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Save(SystemRecord{ID: "../x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(parent, "x.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("saving ../x wrote outside the directory: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "x.json"))
+	if err != nil {
+		t.Errorf("saving ../x did not write x.json: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(parent, "secret.json"), []byte(`{"id": "secret"}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.Load("../secret")
+	if !errors.Is(err, ErrSystemNotFound) {
+		t.Errorf("loading ../secret returned %v", err)
+	}
+}
+
+// TestStoreLoadsOnGet finds a system that is only in storage, such as one
+// saved before a restart, and keeps it in memory afterwards.
+func TestStoreLoadsOnGet(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Save(SystemRecord{
+		ID:    "saved",
+		Nodes: []NodeRecord{{ID: "a", Type: ServerType}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewSystemStore(storage, Limits{}, nil)
+	if len(store.systems) != 0 {
+		t.Fatalf("the store loaded %d systems up front", len(store.systems))
+	}
+
+	system, err := store.Get("saved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+	if nodes := system.Record().Nodes; len(nodes) != 1 || nodes[0].ID != "a" {
+		t.Errorf("loaded nodes %+v", nodes)
+	}
+	again, err := store.Get("saved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != system {
+		t.Error("a second Get loaded the system again")
+	}
+
+	_, err = store.Get("missing")
+	if !errors.Is(err, ErrSystemNotFound) {
+		t.Errorf("getting a missing system returned %v", err)
+	}
+}
+
+func TestSaveRunKeepsLatest(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < MaxRuns+3; i++ {
+		err = storage.SaveRun(RunRecord{
+			ID:        fmt.Sprintf("run%02d", i),
+			SystemID:  "system",
+			StartedAt: start.Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := storage.LoadRuns("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != MaxRuns {
+		t.Fatalf("kept %d runs, expected %d", len(records), MaxRuns)
+	}
+	for _, record := range records {
+		if record.ID < "run03" {
+			t.Errorf("kept old run %s", record.ID)
+		}
+	}
+}
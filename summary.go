@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunSummary describes how a run went, overall as seen by the clients and
+// for each node. Duration is in simulated milliseconds and throughput is per
+// simulated second.
+type RunSummary struct {
+	Duration   int            `json:"duration"`
+	Requests   int            `json:"requests"`
+	Throughput float64        `json:"throughput"`
+	Latency    LatencySummary `json:"latency"`
+	Errors     int            `json:"errors"`
+	ErrorRate  float64        `json:"errorRate"`
+	Nodes      []NodeSummary  `json:"nodes"`
+}
+
+// NodeSummary describes how one node fared in a run. Handled counts the
+// responses a client received or the requests any other node passed on or
+// answered. A client's Latency is how long its requests took end to end.
+// For any other node, Wait is how long requests queued for it and Latency
+// how long it then spent on them.
+type NodeSummary struct {
+	NodeID     string          `json:"nodeId"`
+	Type       string          `json:"type"`
+	Handled    int             `json:"handled"`
+	Throughput float64         `json:"throughput"`
+	Latency    *LatencySummary `json:"latency,omitempty"`
+	Wait       *LatencySummary `json:"wait,omitempty"`
+	Errors     int             `json:"errors"`
+	ErrorRate  float64         `json:"errorRate"`
+	Load       *LoadSummary    `json:"load,omitempty"`
+}
+
+// LoadSummary describes how busy a node was over a run, from the samples it
+// took at every metrics interval. Utilisation is a percentage.
+type LoadSummary struct {
+	Utilisation float64 `json:"utilisation"`
+	Queued      float64 `json:"queued"`
+	MaxQueued   int     `json:"maxQueued"`
+}
+
+// Load accumulates the utilisation and queue depth a node samples over a
+// run. It is safe for concurrent use.
+type Load struct {
+	mutex       sync.Mutex
+	samples     int64
+	utilisation int64
+	queued      int64
+	maxQueued   int64
+}
+
+func NewNodeSummary(node Node, elapsed time.Duration, handled int64, failed int64, latencies *Histogram) NodeSummary {
+	summary := NodeSummary{
+		NodeID:     node.GetID(),
+		Type:       node.GetType(),
+		Handled:    int(handled),
+		Throughput: perSecond(handled, elapsed),
+		Errors:     int(failed),
+		ErrorRate:  percent(failed, handled),
+	}
+	if latencies != nil {
+		latency := latencies.Summary()
+		summary.Latency = &latency
+	}
+	return summary
+}
+
+// summarize describes the run that just ended from its nodes. Callers must
+// hold the mutex.
+func (s *System) summarize() RunSummary {
+	elapsed := s.clock.Elapsed()
+
+	latencies := NewHistogram()
+	var requests, failed int64
+	nodes := []NodeSummary{}
+	for _, node := range s.nodeStore {
+		nodes = append(nodes, node.Summary(elapsed))
+
+		client, ok := node.(*Client)
+		if ok {
+			latencies.Merge(client.latencies)
+			requests += atomic.LoadInt64(&client.numResponses)
+			failed += atomic.LoadInt64(&client.numFailed)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+
+	return RunSummary{
+		Duration:   int(elapsed.Milliseconds()),
+		Requests:   int(requests),
+		Throughput: perSecond(requests, elapsed),
+		Latency:    latencies.Summary(),
+		Errors:     int(failed),
+		ErrorRate:  percent(failed, requests),
+		Nodes:      nodes,
+	}
+}
+
+func NewLoad() *Load {
+	return &Load{}
+}
+
+func (l *Load) Sample(utilisation int, queued int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.samples++
+	l.utilisation += int64(utilisation)
+	l.queued += int64(queued)
+	if int64(queued) > l.maxQueued {
+		l.maxQueued = int64(queued)
+	}
+}
+
+func (l *Load) Summary() LoadSummary {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	summary := LoadSummary{MaxQueued: int(l.maxQueued)}
+	if l.samples > 0 {
+		summary.Utilisation = math.Round(float64(l.utilisation)/float64(l.samples)*100) / 100
+		summary.Queued = math.Round(float64(l.queued)/float64(l.samples)*100) / 100
+	}
+	return summary
+}
+
+func perSecond(count int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return math.Round(float64(count)/elapsed.Seconds()*100) / 100
+}
+
+func percent(part int64, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*10000) / 100
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	system, err := CompileDSL("client a -> server s\nclient b -> s", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two clients, one of which saw every error
+	latencies := map[string][]int64{"a": {10, 20, 30}, "b": {40, 1000}}
+	failures := map[string]int64{"a": 0, "b": 2}
+	clients := []*Client{}
+	var server *Server
+	for _, node := range system.nodeStore {
+		switch node := node.(type) {
+		case *Client:
+			clients = append(clients, node)
+		case *Server:
+			server = node
+		}
+	}
+	if len(clients) != 2 || server == nil {
+		t.Fatalf("compiled %d clients and server %v", len(clients), server)
+	}
+	for i, name := range []string{"a", "b"} {
+		client := clients[i]
+		for _, latency := range latencies[name] {
+			client.latencies.Record(latency)
+		}
+		atomic.StoreInt64(&client.numResponses, int64(len(latencies[name])))
+		atomic.StoreInt64(&client.numFailed, failures[name])
+	}
+	server.load.Sample(50, 2)
+	server.load.Sample(100, 5)
+	server.load.Sample(0, 0)
+
+	system.clock.now = 2 * time.Second
+	system.mutex.Lock()
+	summary := system.summarize()
+	system.mutex.Unlock()
+
+	if summary.Duration != 2000 || summary.Requests != 5 || summary.Throughput != 2.5 {
+		t.Errorf("got duration %d, requests %d and throughput %v", summary.Duration, summary.Requests, summary.Throughput)
+	}
+	if summary.Errors != 2 || summary.ErrorRate != 40 {
+		t.Errorf("got %d errors, an error rate of %v%%", summary.Errors, summary.ErrorRate)
+	}
+	if summary.Latency.Mean != 220 || summary.Latency.Max != 1000 || summary.Latency.P99 != 1000 {
+		t.Errorf("got latency %+v of both clients", summary.Latency)
+	}
+	checkPercentile(t, "p50 of both clients", int64(summary.Latency.P50), 30)
+
+	if len(summary.Nodes) != 3 {
+		t.Fatalf("got %d node summaries, expected 3", len(summary.Nodes))
+	}
+	for i, node := range summary.Nodes {
+		if i > 0 && summary.Nodes[i-1].NodeID >= node.NodeID {
+			t.Errorf("node summaries are not in order of ID")
+		}
+		if node.Type != ServerType {
+			continue
+		}
+		if node.Load == nil || *node.Load != (LoadSummary{Utilisation: 50, Queued: 2.33, MaxQueued: 5}) {
+			t.Errorf("got server load %+v", node.Load)
+		}
+	}
+}
+
+func TestSummaryRates(t *testing.T) {
+	if rate := perSecond(10, 0); rate != 0 {
+		t.Errorf("got %v per second over no time", rate)
+	}
+	if rate := perSecond(10, 3*time.Second); rate != 3.33 {
+		t.Errorf("got %v per second, expected 3.33", rate)
+	}
+	if rate := percent(1, 0); rate != 0 {
+		t.Errorf("got %v%% of nothing", rate)
+	}
+	if rate := percent(1, 3); rate != 33.33 {
+		t.Errorf("got %v%%, expected 33.33", rate)
+	}
+	if load := NewLoad().Summary(); load != (LoadSummary{}) {
+		t.Errorf("got load %+v without samples", load)
+	}
+}
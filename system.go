@@ -5,8 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lithammer/shortuuid/v3"
+	"hash/fnv"
 	"log"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,8 +20,35 @@ type Node interface {
 	GetMetrics() []Metric
 	GetPosition() Position
 	SetPosition(Position)
+	GetConfig() NodeConfig
+	SetConfig(NodeConfig) error
 	Run(context.Context, *sync.WaitGroup, context.CancelFunc)
-	Reset()
+	Reset(RunConfig)
+	Summary(elapsed time.Duration) NodeSummary
+}
+
+// NodeConfig holds the tunable settings of a node. Zero fields are unset and
+// leave the node's current value in place.
+type NodeConfig struct {
+	MaxRoutines         int `json:"maxRoutines,omitempty" yaml:"maxRoutines,omitempty"`
+	ProcessingTimeLower int `json:"processingTimeLower,omitempty" yaml:"processingTimeLower,omitempty"`
+	ProcessingTimeUpper int `json:"processingTimeUpper,omitempty" yaml:"processingTimeUpper,omitempty"`
+	HitRate             int `json:"hitRate,omitempty" yaml:"hitRate,omitempty"`
+}
+
+// RunConfig is handed to every node when a run starts.
+type RunConfig struct {
+	Workload Workload
+	Seed     int64
+	Tracer   *Tracer
+}
+
+// NodeSeed derives a per-node random seed so that nodes sharing a run seed
+// still draw different, but repeatable, numbers.
+func NodeSeed(seed int64, nodeID string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(nodeID))
+	return seed ^ int64(hash.Sum64())
 }
 
 type Edge struct {
@@ -34,14 +65,45 @@ type Receiver interface {
 	SetInChannels(chan Request, chan Response)
 }
 
+// Target is the sending end of an edge, held by the upstream node.
+type Target struct {
+	OutRequests  chan Request
+	OutResponses chan Response
+}
+
+// Source is the receiving end of an edge, held by the downstream node.
+type Source struct {
+	InRequests  chan Request
+	InResponses chan Response
+}
+
+// Request is passed from node to node. Every sender stamps when it queued
+// the request for the next node. A traced request also carries its trace ID
+// and the span of the hop that sent it on.
 type Request struct {
-	ID     int
-	SentAt time.Time
+	ID         int
+	Origin     string
+	SentAt     time.Time
+	TraceID    string
+	SpanID     string
+	EnqueuedAt time.Time
+}
+
+// Key identifies the request across clients, whose request IDs overlap.
+func (r Request) Key() string {
+	return fmt.Sprintf("%s/%d", r.Origin, r.ID)
 }
 
 type Response struct {
 	ID         int
+	Origin     string
 	ReceivedAt time.Time
+	Failed     bool
+	TraceID    string
+}
+
+func (r Response) Key() string {
+	return fmt.Sprintf("%s/%d", r.Origin, r.ID)
 }
 
 type Position struct {
@@ -53,115 +115,1015 @@ const (
 	ClientType       string = "client"
 	ServerType       string = "server"
 	LoadBalancerType string = "load balancer"
+	DatabaseType     string = "database"
+	CacheType        string = "cache"
+	QueueType        string = "queue"
+)
+
+const (
+	IdleState      string = "idle"
+	RunningState   string = "running"
+	PausedState    string = "paused"
+	CompletedState string = "completed"
+	FailedState    string = "failed"
+)
+
+const StepTimeout = 5 * time.Second
+
+var (
+	ErrInvalidState = errors.New("invalid system state")
+	ErrNodeNotFound = errors.New("node not found")
+	ErrInvalidEdge  = errors.New("invalid edge")
+	// ErrSystemUnloaded is returned when a system is deleted or evicted
+	// while being edited, so the edit could not be saved.
+	ErrSystemUnloaded = errors.New("system was unloaded before the edit could be saved")
 )
 
+// System is safe for concurrent use. The mutex guards the design and the
+// run state, while runMutex serialises lifecycle commands so that only one
+// start, stop, pause, resume or step is in flight at a time.
 type System struct {
 	ID        string
+	ParentID  string
 	nodeStore map[string]Node
 	edgeStore map[string]Edge
 
-	messages chan Message
+	hub      *Hub
+	clock    *Clock
+	workload Workload
+	seed     int64
+	history  History
+	metadata Metadata
+	limits   Limits
+	limiter  *RunLimiter
+	runEnded func(*Run)
 
-	isResetting bool
+	mutex      sync.RWMutex
+	state      string
+	generation int
+	failure    string
+	holdsRun   bool
+	runs       []*Run
+	run        *Run
 
+	runMutex   sync.Mutex
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 	wg         *sync.WaitGroup
 }
 
+type SystemStatus struct {
+	State    string   `json:"state"`
+	RunID    string   `json:"runId,omitempty"`
+	Speed    float64  `json:"speed"`
+	Elapsed  int      `json:"elapsed"`
+	Progress Progress `json:"progress"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type Progress struct {
+	Completed int     `json:"completed"`
+	Total     int     `json:"total"`
+	Percent   float64 `json:"percent"`
+}
+
 func NewSystem() *System {
 	wg := &sync.WaitGroup{}
 	ctx, cancel := context.WithCancel(context.Background())
-	messages := make(chan Message)
 
 	return &System{
-		ID:          shortuuid.New(),
-		nodeStore:   map[string]Node{},
-		edgeStore:   map[string]Edge{},
-		messages:    messages,
-		isResetting: false,
-		ctx:         ctx,
-		cancelFunc:  cancel,
-		wg:          wg,
+		ID:         shortuuid.New(),
+		nodeStore:  map[string]Node{},
+		edgeStore:  map[string]Edge{},
+		hub:        NewHub(),
+		clock:      NewClock(),
+		workload:   DefaultWorkload(),
+		metadata:   NewMetadata(),
+		state:      IdleState,
+		ctx:        ctx,
+		cancelFunc: cancel,
+		wg:         wg,
 	}
 }
 
-func (s *System) AddNode(nodeType string) Node {
-	var node Node
+// NewSystemFromRecord rebuilds a persisted system, keeping its node and edge IDs.
+func NewSystemFromRecord(record SystemRecord) (*System, error) {
+	system := NewSystem()
+	system.ID = record.ID
+	system.ParentID = record.ParentID
+
+	if !record.Metadata.CreatedAt.IsZero() {
+		system.metadata = record.Metadata
+		system.metadata.Tags = NormaliseTags(record.Metadata.Tags)
+	} else {
+		system.metadata.Name = record.Metadata.Name
+		system.metadata.Description = record.Metadata.Description
+		system.metadata.Tags = NormaliseTags(record.Metadata.Tags)
+	}
+	err := system.metadata.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if record.Speed != 0 {
+		err := system.clock.SetSpeed(record.Speed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if record.Workload != (Workload{}) {
+		err := record.Workload.Validate()
+		if err != nil {
+			return nil, err
+		}
+		system.workload = record.Workload
+	}
+	system.seed = record.Seed
+
+	for _, nodeRecord := range record.Nodes {
+		if !IsNodeType(nodeRecord.Type) {
+			return nil, fmt.Errorf("node %s has unknown type %q", nodeRecord.ID, nodeRecord.Type)
+		}
+		node := system.addNode(nodeRecord.ID, nodeRecord.Type)
+		node.SetPosition(nodeRecord.Position)
+
+		err := node.SetConfig(nodeRecord.Config)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %w", nodeRecord.ID, err)
+		}
+	}
+
+	for _, edgeRecord := range record.Edges {
+		_, err := system.addEdge(edgeRecord.ID, edgeRecord.Source, edgeRecord.Target)
+		if errors.Is(err, ErrNodeNotFound) {
+			// Older designs kept edges to deleted nodes, drop them
+			log.Printf("system %s dropping edge %s: %v", record.ID, edgeRecord.ID, err)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("edge %s: %w", edgeRecord.ID, err)
+		}
+	}
+	system.history = NewHistoryFromRecord(record.History)
+
+	return system, nil
+}
+
+// Record captures the system's design, without its edit history.
+func (s *System) Record() SystemRecord {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.record()
+}
+
+// StorageRecord captures the design along with the edit history, so that
+// undo keeps working once the system is loaded again.
+func (s *System) StorageRecord() SystemRecord {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record := s.record()
+	record.History = s.history.Record()
+	return record
+}
+
+// record snapshots the design. Callers must hold the mutex.
+func (s *System) record() SystemRecord {
+	metadata := s.metadata
+	metadata.Tags = append([]string{}, s.metadata.Tags...)
+
+	record := SystemRecord{
+		ID:       s.ID,
+		ParentID: s.ParentID,
+		Metadata: metadata,
+		Speed:    s.clock.Speed(),
+		Workload: s.workload,
+		Seed:     s.seed,
+		Nodes:    []NodeRecord{},
+		Edges:    []EdgeRecord{},
+	}
+
+	for _, node := range s.nodeStore {
+		record.Nodes = append(record.Nodes, NodeRecord{
+			ID:       node.GetID(),
+			Type:     node.GetType(),
+			Position: node.GetPosition(),
+			Config:   node.GetConfig(),
+		})
+	}
+	sort.Slice(record.Nodes, func(i, j int) bool {
+		return record.Nodes[i].ID < record.Nodes[j].ID
+	})
+
+	for _, edge := range s.edgeStore {
+		record.Edges = append(record.Edges, EdgeRecord{
+			ID:     edge.ID,
+			Source: edge.SourceID,
+			Target: edge.TargetID,
+		})
+	}
+	sort.Slice(record.Edges, func(i, j int) bool {
+		return record.Edges[i].ID < record.Edges[j].ID
+	})
+
+	return record
+}
+
+// Fork copies the design into a new system with fresh system, node and
+// edge IDs, linked back to this one as its parent.
+func (s *System) Fork() (*System, error) {
+	record := s.Record()
+
+	forked, err := NewSystemDocument(record).Record()
+	if err != nil {
+		return nil, err
+	}
+	forked.ParentID = s.ID
+	forked.Speed = record.Speed
+
+	return NewSystemFromRecord(forked)
+}
+
+// Metrics returns the live metrics of every node, keyed by node ID.
+func (s *System) Metrics() map[string][]Metric {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	metrics := map[string][]Metric{}
+	for id, node := range s.nodeStore {
+		metrics[id] = node.GetMetrics()
+	}
+
+	return metrics
+}
+
+func IsNodeType(nodeType string) bool {
 	switch nodeType {
-	case ClientType:
-		node = NewClient(s.messages)
-	case ServerType:
-		node = NewServer(s.messages)
-	case LoadBalancerType:
-		node = NewLoadBalancer(s.messages)
+	case ClientType, ServerType, LoadBalancerType, DatabaseType, CacheType, QueueType:
+		return true
+	}
+	return false
+}
+
+// AddNode places a new node of the type. Like every design edit except
+// moving a node, it needs the system to be stopped.
+func (s *System) AddNode(nodeType string) (Node, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.expectStopped()
+	if err != nil {
+		return nil, err
 	}
+	err = s.limits.CheckDesign(len(s.nodeStore)+1, len(s.edgeStore))
+	if err != nil {
+		return nil, err
+	}
+
+	before := s.record()
+
+	node := s.addNode(shortuuid.New(), nodeType)
 	node.SetPosition(Position{
 		X: 500,
 		Y: 150,
 	})
+
+	s.commit("add "+nodeType, before)
+	return node, nil
+}
+
+func (s *System) addNode(id string, nodeType string) Node {
+	var node Node
+	switch nodeType {
+	case ClientType:
+		node = NewClient(id, s.hub, s.clock)
+	case ServerType:
+		node = NewServer(id, s.hub, s.clock)
+	case LoadBalancerType:
+		node = NewLoadBalancer(id, s.hub, s.clock)
+	case DatabaseType:
+		node = NewDatabase(id, s.hub, s.clock)
+	case CacheType:
+		node = NewCache(id, s.hub, s.clock)
+	case QueueType:
+		node = NewQueue(id, s.hub, s.clock)
+	}
 	s.nodeStore[node.GetID()] = node
 
 	return node
 }
 
+func (s *System) MoveNode(nodeID string, position Position) error {
+	return s.UpdateNode(nodeID, nil, &position)
+}
+
+func (s *System) ConfigureNode(nodeID string, config NodeConfig) error {
+	return s.UpdateNode(nodeID, &config, nil)
+}
+
+// UpdateNode changes the node's config, its position or both, as one edit.
+// An invalid config leaves the node untouched. Only moving a node is allowed
+// while the system is running.
+func (s *System) UpdateNode(nodeID string, config *NodeConfig, position *Position) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if config != nil {
+		err := s.expectStopped()
+		if err != nil {
+			return err
+		}
+	}
+	before := s.record()
+
+	node, ok := s.nodeStore[nodeID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNodeNotFound, nodeID)
+	}
+
+	name := "move " + node.GetType()
+	if config != nil {
+		err := node.SetConfig(*config)
+		if err != nil {
+			return err
+		}
+		name = "configure " + node.GetType()
+	}
+	if position != nil {
+		node.SetPosition(*position)
+	}
+	s.commit(name, before)
+
+	return nil
+}
+
+// SetWorkload changes the requests clients send, starting with the next run.
+func (s *System) SetWorkload(workload Workload) error {
+	err := workload.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	before := s.record()
+	s.workload = workload
+	s.commit("change workload", before)
+	return nil
+}
+
+// SetSeed makes the next runs repeatable. A zero seed picks a new one per run.
+func (s *System) SetSeed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	before := s.record()
+
+	s.seed = seed
+	s.commit("change seed", before)
+}
+
+// DeleteNodes removes the nodes along with every edge connected to them, and
+// returns the removed edges.
+func (s *System) DeleteNodes(nodeIDs []string) ([]Edge, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.expectStopped()
+	if err != nil {
+		return nil, err
+	}
+	before := s.record()
+
+	deleted := map[string]bool{}
+	for _, nodeID := range nodeIDs {
+		log.Printf("deleting system %s node %s", s.ID, nodeID)
+		delete(s.nodeStore, nodeID)
+		s.hub.Forget(nodeID)
+		deleted[nodeID] = true
+	}
+
+	edges := []Edge{}
+	for edgeID, edge := range s.edgeStore {
+		if deleted[edge.SourceID] || deleted[edge.TargetID] {
+			log.Printf("deleting system %s edge %s", s.ID, edgeID)
+			delete(s.edgeStore, edgeID)
+			edges = append(edges, edge)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].ID < edges[j].ID
+	})
+	s.commit("delete nodes", before)
+
+	return edges, nil
+}
+
+// AddEdge connects a node that sends requests to one that receives them.
+func (s *System) AddEdge(senderID string, receiverID string) (Edge, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.expectStopped()
+	if err != nil {
+		return Edge{}, err
+	}
+	before := s.record()
+	edge, err := s.addEdge(shortuuid.New(), senderID, receiverID)
+	if err != nil {
+		return Edge{}, err
+	}
+	s.commit("add edge", before)
+
+	return edge, nil
+}
+
+// addEdge checks that both ends exist and fit together. Callers must hold
+// the mutex.
+func (s *System) addEdge(id string, senderID string, receiverID string) (Edge, error) {
+	sender, ok := s.nodeStore[senderID]
+	if !ok {
+		return Edge{}, fmt.Errorf("%w: source %s", ErrNodeNotFound, senderID)
+	}
+	receiver, ok := s.nodeStore[receiverID]
+	if !ok {
+		return Edge{}, fmt.Errorf("%w: target %s", ErrNodeNotFound, receiverID)
+	}
+
+	if _, ok := sender.(Sender); !ok {
+		return Edge{}, fmt.Errorf("%w: %s cannot send requests", ErrInvalidEdge, sender.GetType())
+	}
+	if _, ok := receiver.(Receiver); !ok {
+		return Edge{}, fmt.Errorf("%w: %s cannot receive requests", ErrInvalidEdge, receiver.GetType())
+	}
+	if senderID == receiverID {
+		return Edge{}, fmt.Errorf("%w: %s cannot connect to itself", ErrInvalidEdge, sender.GetType())
+	}
+	for _, edge := range s.edgeStore {
+		if edge.SourceID == senderID && edge.TargetID == receiverID {
+			return Edge{}, fmt.Errorf("%w: %s is already connected to %s", ErrInvalidEdge, senderID, receiverID)
+		}
+	}
+	err := s.limits.CheckDesign(len(s.nodeStore), len(s.edgeStore)+1)
+	if err != nil {
+		return Edge{}, err
+	}
+
+	newEdge := Edge{
+		ID:       id,
+		SourceID: senderID,
+		TargetID: receiverID,
+	}
+	s.edgeStore[newEdge.ID] = newEdge
+
+	return newEdge, nil
+}
+
+func (s *System) DeleteEdges(edgeIDs []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.expectStopped()
+	if err != nil {
+		return err
+	}
+	before := s.record()
+
+	for _, edgeID := range edgeIDs {
+		log.Printf("deleting system %s edge %s", s.ID, edgeID)
+		delete(s.edgeStore, edgeID)
+	}
+	s.commit("delete edges", before)
+
+	return nil
+}
+
 func (s *System) Start() error {
-	if !s.isResetting {
-		log.Printf("system %s reset intitiated", s.ID)
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
 
-		s.cancelFunc()
-		s.isResetting = true
-		s.wg.Wait()
+	return s.start(false)
+}
 
-		wg := &sync.WaitGroup{}
-		s.wg = wg
+// start resets the system and runs every node. A paused start leaves the
+// nodes waiting on the clock, ready to be stepped. Callers must hold runMutex.
+func (s *System) start(paused bool) (err error) {
+	validation := s.Validate()
+	if !validation.Valid {
+		return &ValidationError{Validation: validation}
+	}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		s.ctx = ctx
-		s.cancelFunc = cancel
+	// A restart keeps the slot of the run it replaces
+	s.mutex.Lock()
+	if !s.holdsRun {
+		err := s.limiter.Acquire()
+		if err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		s.holdsRun = true
+	}
+	s.generation++
+	generation := s.generation
+	s.failure = ""
+	maxDuration := s.limits.MaxRunDuration
+	s.mutex.Unlock()
 
-		log.Printf("system %s reset complete", s.ID)
-		s.isResetting = false
+	log.Printf("system %s reset intitiated", s.ID)
+	s.cancelFunc()
+	s.wg.Wait()
+
+	s.wg = &sync.WaitGroup{}
+	s.ctx, s.cancelFunc = context.WithCancel(context.Background())
+	s.mutex.Lock()
+	ended := s.beginRun()
+	s.mutex.Unlock()
+	if ended != nil {
+		s.saveRun(ended)
+	}
+	log.Printf("system %s reset complete", s.ID)
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.cancelFunc()
+			err = fmt.Errorf("system %s failed to start: %v", s.ID, r)
+			s.finish(generation, FailedState, err.Error())
+		}
+	}()
+
+	s.clock.Reset()
+	if paused {
+		s.clock.Pause()
+	}
+
+	log.Printf("starting system %s", s.ID)
+	s.launch()
+
+	if paused {
+		s.setState(PausedState)
 	} else {
-		log.Printf("system %s reset in progress", s.ID)
-		return errors.New(fmt.Sprintf("system %s reset in progress", s.ID))
+		s.setState(RunningState)
+	}
+
+	go s.watch(s.ctx, s.cancelFunc, s.wg, generation, maxDuration)
+
+	return nil
+}
+
+// launch wires up the edges and runs every node.
+func (s *System) launch() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	run := RunConfig{
+		Workload: s.workload,
+		Seed:     s.seed,
+		Tracer:   s.run.tracer,
+	}
+	if run.Seed == 0 {
+		run.Seed = time.Now().UnixNano()
 	}
+	s.run.setSeed(run.Seed)
 
 	for _, node := range s.nodeStore {
-		node.Reset()
+		node.Reset(run)
 	}
 
 	s.InitEdges()
 
-	log.Printf("starting system %s", s.ID)
+	// The run is complete, and its simulated time stops, once every client
+	// has had all of its responses
+	clients := 0
 	for _, node := range s.nodeStore {
-		node.Run(s.ctx, s.wg, s.cancelFunc)
+		if node.GetType() == ClientType {
+			clients++
+		}
+	}
+	var done int64
+	cancelRun := s.cancelFunc
+	cancel := func() {
+		if atomic.AddInt64(&done, 1) >= int64(clients) {
+			s.clock.Stop()
+			cancelRun()
+		}
+	}
+
+	for _, node := range s.nodeStore {
+		node.Run(s.ctx, s.wg, cancel)
+	}
+}
+
+// Close cancels any run, waits for its goroutines to exit and ends every
+// subscription, telling connected clients the system is gone. The system
+// must not be used after.
+func (s *System) Close() {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	s.mutex.Lock()
+	s.generation++
+	s.mutex.Unlock()
+
+	s.cancelFunc()
+	s.wg.Wait()
+	s.clock.Stop()
+	s.endRun(StoppedState, "system closed")
+	s.hub.Close()
+}
+
+// Subscribe starts receiving the system's messages, beginning with its
+// current status and the latest history and metrics of every node.
+func (s *System) Subscribe() *Subscription {
+	// Status is published afresh, as progress moves on between changes
+	s.publish(NewStatusMessage(s.Status()))
+	s.hub.Seed(NewHistoryMessage(s.History()))
+	elapsed := s.clock.Elapsed()
+	for nodeID, metrics := range s.Metrics() {
+		s.hub.Seed(NewMetricsMessage(nodeID, elapsed, metrics))
 	}
 
+	return s.hub.Subscribe()
+}
+
+// SubscribeAfter resumes a subscription after the message numbered seq,
+// replaying what was missed. If that is no longer possible it starts afresh
+// like Subscribe.
+func (s *System) SubscribeAfter(seq int64) *Subscription {
+	sub, ok := s.hub.SubscribeAfter(seq)
+	if !ok {
+		log.Printf("system %s cannot resume after message %d, starting afresh", s.ID, seq)
+		return s.Subscribe()
+	}
+	return sub
+}
+
+func (s *System) Unsubscribe(sub *Subscription) {
+	s.hub.Unsubscribe(sub)
+}
+
+// Watched reports whether any websocket or event stream client is following
+// the system.
+func (s *System) Watched() bool {
+	return s.hub.Subscribers() > 0
+}
+
+// Stop cancels the current run and waits for every node goroutine to exit.
+func (s *System) Stop() error {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	s.mutex.Lock()
+	if s.state != RunningState && s.state != PausedState {
+		defer s.mutex.Unlock()
+		return fmt.Errorf("%w: system %s is %s", ErrInvalidState, s.ID, s.state)
+	}
+	s.generation++
+	s.mutex.Unlock()
+
+	log.Printf("stopping system %s", s.ID)
+	s.cancelFunc()
+	s.wg.Wait()
+	s.clock.Stop()
+	s.endRun(StoppedState, "stopped on request")
+	s.setState(IdleState)
+
 	return nil
 }
 
+func (s *System) Pause() error {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	return s.pause()
+}
+
+// pause freezes the clock of a running system. Callers must hold runMutex.
+func (s *System) pause() error {
+	err := s.expectState(RunningState)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("pausing system %s", s.ID)
+	s.clock.Pause()
+	s.setState(PausedState)
+
+	return nil
+}
+
+func (s *System) Resume() error {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	err := s.expectState(PausedState)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("resuming system %s", s.ID)
+	s.clock.Resume()
+	s.setState(RunningState)
+
+	return nil
+}
+
+// Step advances the simulation by a single event. A running system is paused
+// first and an idle or finished one is started paused.
+func (s *System) Step() (Event, error) {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	s.mutex.RLock()
+	state := s.state
+	s.mutex.RUnlock()
+
+	var err error
+	switch state {
+	case RunningState:
+		err = s.pause()
+	case PausedState:
+	default:
+		err = s.start(true)
+	}
+	if err != nil {
+		return Event{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, StepTimeout)
+	defer cancel()
+
+	event, err := s.clock.Step(ctx)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return Event{}, fmt.Errorf("%w: system %s run has ended", ErrInvalidState, s.ID)
+		}
+		return Event{}, fmt.Errorf("system %s produced no event within %v", s.ID, StepTimeout)
+	}
+
+	log.Printf("system %s stepped: %s", s.ID, event.Description)
+	s.publish(NewEventMessage(event))
+
+	return event, nil
+}
+
+// SetSpeed scales request intervals, processing times and metric ticks,
+// including those of a run already in progress.
+func (s *System) SetSpeed(speed float64) error {
+	err := s.clock.SetSpeed(speed)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("system %s speed set to %vx", s.ID, speed)
+	s.publish(NewStatusMessage(s.Status()))
+
+	return nil
+}
+
+func (s *System) Status() SystemStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	progress := Progress{}
+	for _, node := range s.nodeStore {
+		client, ok := node.(*Client)
+		if !ok {
+			continue
+		}
+		completed, total := client.Progress()
+		progress.Completed += completed
+		progress.Total += total
+	}
+	if progress.Total > 0 {
+		progress.Percent = math.Round(float64(progress.Completed)/float64(progress.Total)*1000) / 10
+	}
+
+	runID := ""
+	if len(s.runs) > 0 {
+		runID = s.runs[len(s.runs)-1].ID
+	}
+
+	return SystemStatus{
+		State:    s.state,
+		RunID:    runID,
+		Speed:    s.clock.Speed(),
+		Elapsed:  int(s.clock.Elapsed().Milliseconds()),
+		Progress: progress,
+		Error:    s.failure,
+	}
+}
+
+func (s *System) expectState(state string) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.state != state {
+		return fmt.Errorf("%w: system %s is %s", ErrInvalidState, s.ID, s.state)
+	}
+	return nil
+}
+
+// watch marks the run as completed once its nodes have shut down, unless the
+// run was stopped or restarted in the meantime. A run still going after
+// maxDuration, paused or not, is cancelled and marked as failed.
+func (s *System) watch(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, generation int, maxDuration time.Duration) {
+	if maxDuration > 0 {
+		timer := time.NewTimer(maxDuration)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			cancel()
+			wg.Wait()
+			s.finish(generation, FailedState, fmt.Sprintf("run stopped after exceeding the %v limit", maxDuration))
+			return
+		}
+	}
+
+	<-ctx.Done()
+
+	// Timers still due while the nodes shut down must not add to the
+	// simulated duration of the run
+	s.mutex.Lock()
+	if s.generation == generation {
+		s.clock.Stop()
+	}
+	s.mutex.Unlock()
+
+	wg.Wait()
+	s.finish(generation, CompletedState, "")
+}
+
+func (s *System) finish(generation int, state string, failure string) {
+	s.mutex.Lock()
+	if s.generation != generation {
+		s.mutex.Unlock()
+		return
+	}
+	s.generation++
+	s.failure = failure
+	s.mutex.Unlock()
+
+	s.clock.Stop()
+	if failure == "" {
+		s.endRun(state, "every request was answered")
+	} else {
+		s.endRun(state, failure)
+	}
+	s.setState(state)
+	log.Printf("system %s %s", s.ID, state)
+}
+
+// endRun records how the run ended, saves it and gives back its slot.
+func (s *System) endRun(state string, reason string) {
+	s.mutex.Lock()
+	ended := s.stopRecording(state, reason)
+	if s.holdsRun {
+		s.limiter.Release()
+		s.holdsRun = false
+	}
+	s.mutex.Unlock()
+
+	if ended != nil {
+		s.saveRun(ended)
+	}
+}
+
+func (s *System) setState(state string) {
+	s.mutex.Lock()
+	s.state = state
+	s.mutex.Unlock()
+
+	s.publish(NewStatusMessage(s.Status()))
+}
+
+// publish sends a message to metrics listeners without blocking the caller.
+func (s *System) publish(msg Message) {
+	s.hub.Publish(msg)
+}
+
+// InitEdges connects the nodes of every edge. Callers must hold the mutex.
+// Edges the validator would reject are skipped.
 func (s *System) InitEdges() {
 	for _, edge := range s.edgeStore {
+		sender, ok := s.nodeStore[edge.SourceID].(Sender)
+		if !ok {
+			log.Printf("system %s skipping edge %s with invalid source", s.ID, edge.ID)
+			continue
+		}
+		receiver, ok := s.nodeStore[edge.TargetID].(Receiver)
+		if !ok {
+			log.Printf("system %s skipping edge %s with invalid target", s.ID, edge.ID)
+			continue
+		}
+
 		requestChan := make(chan Request, 1000)
 		responseChan := make(chan Response, 1000)
 
-		s.nodeStore[edge.SourceID].(Sender).SetOutChannels(requestChan, responseChan)
-		s.nodeStore[edge.TargetID].(Receiver).SetInChannels(requestChan, responseChan)
+		sender.SetOutChannels(requestChan, responseChan)
+		receiver.SetInChannels(requestChan, responseChan)
 	}
 }
 
-func (s *System) AddEdge(senderID string, receiverID string) {
-	newEdge := Edge{
-		ID:       shortuuid.New(),
-		SourceID: senderID,
-		TargetID: receiverID,
+// SystemStore holds the systems in memory and writes their designs through
+// to storage. Systems not yet in memory are loaded on first access, and
+// those left idle may be evicted again. It is safe for concurrent use.
+type SystemStore struct {
+	mutex    sync.Mutex
+	systems  map[string]*System
+	accessed map[string]time.Time
+	storage  Storage
+	limits   Limits
+	limiter  *RunLimiter
+	exporter *TraceExporter
+}
+
+// NewSystemStore creates a store that applies the limits to every system it
+// holds. With an idle TTL, systems left unused are evicted from memory. With
+// an exporter, the traces of every run are sent on once it ends.
+func NewSystemStore(storage Storage, limits Limits, exporter *TraceExporter) *SystemStore {
+	ss := &SystemStore{
+		systems:  map[string]*System{},
+		accessed: map[string]time.Time{},
+		storage:  storage,
+		limits:   limits,
+		limiter:  NewRunLimiter(limits.MaxRunning),
+		exporter: exporter,
 	}
-	s.edgeStore[newEdge.ID] = newEdge
+	if limits.IdleTTL > 0 {
+		go ss.evictIdle()
+	}
+	return ss
 }
 
-type SystemStore map[string]*System
+func (ss *SystemStore) Get(id string) (*System, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
 
-func NewSystemStore() SystemStore {
-	return SystemStore{}
+	system, ok := ss.systems[id]
+	if ok {
+		ss.accessed[id] = time.Now()
+		return system, nil
+	}
+
+	record, err := ss.storage.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	system, err = NewSystemFromRecord(record)
+	if err != nil {
+		return nil, fmt.Errorf("system %s could not be restored: %w", id, err)
+	}
+	log.Printf("system %s restored from storage", id)
+
+	ss.attach(system)
+	ss.systems[id] = system
+	ss.accessed[id] = time.Now()
+	return system, nil
+}
+
+// attach applies the store's limits to the system and has it save the
+// records of its runs. Callers must hold the store mutex.
+func (ss *SystemStore) attach(system *System) {
+	system.mutex.Lock()
+	defer system.mutex.Unlock()
+
+	system.limits = ss.limits
+	system.limiter = ss.limiter
+	system.runEnded = ss.saveRun
+}
+
+// Add stores a new system, unless its design is over the limits.
+func (ss *SystemStore) Add(system *System) error {
+	record := system.Record()
+	err := ss.limits.CheckDesign(len(record.Nodes), len(record.Edges))
+	if err != nil {
+		return err
+	}
+
+	ss.mutex.Lock()
+	ss.attach(system)
+	ss.systems[system.ID] = system
+	ss.accessed[system.ID] = time.Now()
+	ss.mutex.Unlock()
+
+	return ss.Save(system)
+}
+
+// Save persists the current design of the system. Saves are serialised so
+// that the last one to finish always holds the latest design. Systems
+// deleted or evicted in the meantime are not saved again, and the edit is
+// reported as lost so that it can be retried.
+func (ss *SystemStore) Save(system *System) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	if ss.systems[system.ID] != system {
+		return fmt.Errorf("%w: system %s was deleted or evicted", ErrSystemUnloaded, system.ID)
+	}
+	return ss.storage.Save(system.StorageRecord())
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStepDeliversEvents starts an idle system paused and steps one request
+// through it, publishing each event as it is stepped.
+func TestStepDeliversEvents(t *testing.T) {
+	system, err := CompileDSL("workload {numRequests: 1, requestInterval: 10}\nclient -> server", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+	sub := system.hub.Listen(SubscriberBuffer)
+
+	kinds := []string{RequestSentEvent, ProcessingStartedEvent, ProcessingCompletedEvent, ResponseReceivedEvent}
+	previous := 0
+	for _, kind := range kinds {
+		event, err := system.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Kind != kind || event.RequestID != 0 {
+			t.Errorf("stepped %s of request %d, expected %s of request 0", event.Kind, event.RequestID, kind)
+		}
+		if event.Time < previous {
+			t.Errorf("%s at %dms went back in time from %dms", event.Kind, event.Time, previous)
+		}
+		previous = event.Time
+		if state := system.Status().State; state != PausedState {
+			t.Errorf("the system is %s after stepping, expected paused", state)
+		}
+
+		delivered := false
+		timeout := time.After(time.Second)
+		for !delivered {
+			select {
+			case msg := <-sub.Messages():
+				if msg.Type == EventMessage {
+					if *msg.Event != event {
+						t.Errorf("published %+v for step %+v", *msg.Event, event)
+					}
+					delivered = true
+				}
+			case <-timeout:
+				t.Fatalf("the %s step was not published", kind)
+			}
+		}
+	}
+}
+
+func TestFork(t *testing.T) {
+	system, err := CompileDSL("client -> lb -> [server x2] -> db", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close()
+	err = system.SetSpeed(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = system.AddNode(CacheType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forked, err := system.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forked.Close()
+
+	original := system.Record()
+	copied := forked.Record()
+	if copied.ID == original.ID || copied.ParentID != original.ID {
+		t.Errorf("forked %s with parent %q from %s", copied.ID, copied.ParentID, original.ID)
+	}
+	if copied.Speed != 5 {
+		t.Errorf("forked speed is %v, expected 5", copied.Speed)
+	}
+	if undo := forked.History().Undo; len(undo) != 0 {
+		t.Errorf("the fork inherited the history %v", undo)
+	}
+
+	originalIDs := map[string]bool{}
+	originalTypes := map[string]int{}
+	for _, node := range original.Nodes {
+		originalIDs[node.ID] = true
+		originalTypes[node.Type]++
+	}
+	copiedIDs := map[string]bool{}
+	copiedTypes := map[string]int{}
+	for _, node := range copied.Nodes {
+		if originalIDs[node.ID] {
+			t.Errorf("the fork reuses node ID %s", node.ID)
+		}
+		copiedIDs[node.ID] = true
+		copiedTypes[node.Type]++
+	}
+	if !reflect.DeepEqual(copiedTypes, originalTypes) {
+		t.Errorf("forked nodes %v from %v", copiedTypes, originalTypes)
+	}
+
+	if len(copied.Edges) != len(original.Edges) {
+		t.Fatalf("forked %d edges from %d", len(copied.Edges), len(original.Edges))
+	}
+	for _, edge := range copied.Edges {
+		if !copiedIDs[edge.Source] || !copiedIDs[edge.Target] {
+			t.Errorf("forked edge %+v does not connect forked nodes", edge)
+		}
+	}
+}
@@ -1,40 +1,76 @@
 package main
 
-func DefaultSystem(system *System) *System {
-	client := system.AddNode(ClientType)
-	client.SetPosition(Position{
-		X: 400,
-		Y: 425,
-	})
-
-	loadBalancer := system.AddNode(LoadBalancerType)
-	loadBalancer.SetPosition(Position{
-		X: 700,
-		Y: 425,
-	})
-
-	server1 := system.AddNode(ServerType)
-	server1.SetPosition(Position{
-		X: 1050,
-		Y: 225,
-	})
-
-	server2 := system.AddNode(ServerType)
-	server2.SetPosition(Position{
-		X: 1050,
-		Y: 425,
-	})
-
-	server3 := system.AddNode(ServerType)
-	server3.SetPosition(Position{
-		X: 1050,
-		Y: 625,
-	})
-
-	system.AddEdge(client.GetID(), loadBalancer.GetID())
-	system.AddEdge(loadBalancer.GetID(), server1.GetID())
-	system.AddEdge(loadBalancer.GetID(), server2.GetID())
-	system.AddEdge(loadBalancer.GetID(), server3.GetID())
-
-	return system
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTemplateNotFound = errors.New("template not found")
+
+// DefaultTemplate is used when a system is created without naming a template.
+const DefaultTemplate = "load-balanced"
+
+// Template is a named starting design, written in the design DSL.
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	DSL         string `json:"dsl"`
+}
+
+var Templates = []Template{
+	{
+		Name:        "load-balanced",
+		Description: "A client sending requests through a load balancer to three servers.",
+		DSL:         "client -> lb -> [server x3]",
+	},
+	{
+		Name:        "three-tier",
+		Description: "A three-tier web app: a load balancer, three application servers and a shared database.",
+		DSL:         "client -> lb -> [server app x3] -> db",
+	},
+	{
+		Name:        "cache-aside",
+		Description: "Application servers read through a cache, and only cache misses reach the database.",
+		DSL:         "client -> lb -> [server api x3] -> cache {hitRate: 80} -> db",
+	},
+	{
+		Name:        "worker-pool",
+		Description: "A queue handing jobs to whichever of five small workers has the shortest backlog.",
+		DSL:         "client producer -> queue jobs -> [server worker x5 {maxRoutines: 4}] -> db",
+	},
+	{
+		Name:        "cqrs",
+		Description: "CQRS: reads scale out over query servers and a read replica, while writes go to a single command server and the primary database.",
+		DSL: "client readers -> lb -> [server query x3] -> db replica\n" +
+			"client writers -> server command -> db primary {maxRoutines: 10}",
+	},
+	{
+		Name:        "multi-region",
+		Description: "Multi-region active-active: each region serves its own users and its servers write to the databases of both regions.",
+		DSL: "client east-users -> lb east -> [server east-app x3] -> [db east-db, db west-db]\n" +
+			"client west-users -> lb west -> [server west-app x3] -> [east-db, west-db]",
+	},
+}
+
+func FindTemplate(name string) (Template, error) {
+	for _, template := range Templates {
+		if template.Name == name {
+			return template, nil
+		}
+	}
+	return Template{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
+
+// NewSystemFromTemplate creates a system laid out like the named template.
+func NewSystemFromTemplate(name string) (*System, error) {
+	template, err := FindTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	system, err := CompileDSL(template.DSL, Limits{})
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return system, nil
 }
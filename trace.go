@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTraceNotFound    = errors.New("trace not found")
+	ErrAmbiguousRequest = errors.New("several clients sent the request")
+)
+
+// MaxTraces is how many requests of a run are traced. Later ones are not.
+const MaxTraces = 5000
+
+// Span is one hop of a traced request: the time it waited in the node's
+// queue, from EnqueuedAt to StartedAt, and the time the node spent on it
+// until it answered at EndedAt. Processing is the part of that the node
+// spent working rather than waiting on the nodes it called.
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	NodeID     string
+	NodeType   string
+	RequestID  int
+	Origin     string
+	EnqueuedAt time.Time
+	StartedAt  time.Time
+	EndedAt    time.Time
+	Processing time.Duration
+	Failed     bool
+	ended      bool
+}
+
+// Tracer records the spans of the requests of a run as they pass from node
+// to node. Each request carries its trace ID and the span of the hop that
+// sent it. A nil tracer records nothing. It is safe for concurrent use.
+type Tracer struct {
+	runID string
+
+	mutex  sync.Mutex
+	traces map[string][]*Span
+	order  []string
+}
+
+func NewTracer(runID string) *Tracer {
+	return &Tracer{
+		runID:  runID,
+		traces: map[string][]*Span{},
+	}
+}
+
+// TraceID names the trace of a client's request. It is derived from the run
+// so that the trace of a request can be looked up by its number.
+func (t *Tracer) TraceID(origin string, requestID int) string {
+	hash := fnv.New128a()
+	fmt.Fprintf(hash, "%s/%s/%d", t.runID, origin, requestID)
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Begin starts the trace of a request a client is sending, unless the run
+// already traced as many as it keeps.
+func (t *Tracer) Begin(node Node, request Request, at time.Time) Request {
+	if t == nil {
+		return request
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.order) >= MaxTraces {
+		return request
+	}
+	request.TraceID = t.TraceID(request.Origin, request.ID)
+	request.SpanID = ""
+	t.order = append(t.order, request.TraceID)
+	return t.start(node, request, at)
+}
+
+// Start records that the node took up a traced request at the given time,
+// and returns the request to pass on to the nodes it calls.
+func (t *Tracer) Start(node Node, request Request, at time.Time) Request {
+	if t == nil || request.TraceID == "" {
+		return request
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.start(node, request, at)
+}
+
+// start adds the node's span. Callers must hold the mutex.
+func (t *Tracer) start(node Node, request Request, at time.Time) Request {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s/%s", request.TraceID, node.GetID())
+	span := &Span{
+		TraceID:    request.TraceID,
+		SpanID:     fmt.Sprintf("%016x", hash.Sum64()),
+		ParentID:   request.SpanID,
+		NodeID:     node.GetID(),
+		NodeType:   node.GetType(),
+		RequestID:  request.ID,
+		Origin:     request.Origin,
+		EnqueuedAt: request.EnqueuedAt,
+		StartedAt:  at,
+	}
+	t.traces[span.TraceID] = append(t.traces[span.TraceID], span)
+
+	request.SpanID = span.SpanID
+	return request
+}
+
+// End records that the node answered a traced request, after processing it
+// for the given time.
+func (t *Tracer) End(node Node, traceID string, at time.Time, processing time.Duration, failed bool) {
+	if t == nil || traceID == "" {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, span := range t.traces[traceID] {
+		if span.NodeID == node.GetID() && !span.ended {
+			span.EndedAt = at
+			span.Processing = processing
+			span.Failed = failed
+			span.ended = true
+			return
+		}
+	}
+}
+
+// Spans returns a copy of the spans of a trace, in the order they started.
+func (t *Tracer) Spans(traceID string) ([]Span, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	spans, ok := t.traces[traceID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTraceNotFound, traceID)
+	}
+
+	copied := []Span{}
+	for _, span := range spans {
+		copied = append(copied, *span)
+	}
+	return copied, nil
+}
+
+// Ended returns a copy of every span that ended, by trace in the order the
+// traces began.
+func (t *Tracer) Ended() [][]Span {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	traces := [][]Span{}
+	for _, traceID := range t.order {
+		spans := []Span{}
+		for _, span := range t.traces[traceID] {
+			if span.ended {
+				spans = append(spans, *span)
+			}
+		}
+		if len(spans) > 0 {
+			traces = append(traces, spans)
+		}
+	}
+	return traces
+}
+
+// Waterfall lays out the hops of a request against its start, so that a slow
+// request can be explained hop by hop. Times are in simulated milliseconds.
+type Waterfall struct {
+	TraceID   string          `json:"traceId"`
+	RequestID int             `json:"requestId"`
+	Origin    string          `json:"origin"`
+	Duration  int             `json:"duration"`
+	Failed    bool            `json:"failed"`
+	Spans     []WaterfallSpan `json:"spans"`
+}
+
+// WaterfallSpan is a hop of a waterfall. Offset is when the request reached
+// the node, after which it waited Wait in the queue. Duration is how long
+// the node then took to answer, of which Processing was its own work. A hop
+// still in progress has no duration.
+type WaterfallSpan struct {
+	SpanID     string `json:"spanId"`
+	ParentID   string `json:"parentId,omitempty"`
+	NodeID     string `json:"nodeId"`
+	NodeType   string `json:"nodeType"`
+	Depth      int    `json:"depth"`
+	Offset     int    `json:"offset"`
+	Wait       int    `json:"wait"`
+	Duration   int    `json:"duration"`
+	Processing int    `json:"processing"`
+	Failed     bool   `json:"failed"`
+	InProgress bool   `json:"inProgress,omitempty"`
+}
+
+func NewWaterfall(spans []Span) Waterfall {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].StartedAt.Before(spans[j].StartedAt)
+	})
+
+	root := spans[0]
+	waterfall := Waterfall{
+		TraceID:   root.TraceID,
+		RequestID: root.RequestID,
+		Origin:    root.Origin,
+		Failed:    root.Failed,
+		Spans:     []WaterfallSpan{},
+	}
+	if root.ended {
+		waterfall.Duration = int(root.EndedAt.Sub(root.StartedAt).Milliseconds())
+	}
+
+	depths := map[string]int{}
+	for _, span := range spans {
+		depth := 0
+		if parentDepth, ok := depths[span.ParentID]; ok {
+			depth = parentDepth + 1
+		}
+		depths[span.SpanID] = depth
+
+		hop := WaterfallSpan{
+			SpanID:     span.SpanID,
+			ParentID:   span.ParentID,
+			NodeID:     span.NodeID,
+			NodeType:   span.NodeType,
+			Depth:      depth,
+			Offset:     int(span.EnqueuedAt.Sub(root.StartedAt).Milliseconds()),
+			Wait:       int(span.StartedAt.Sub(span.EnqueuedAt).Milliseconds()),
+			Processing: int(span.Processing.Milliseconds()),
+			Failed:     span.Failed,
+			InProgress: !span.ended,
+		}
+		if span.ended {
+			hop.Duration = int(span.EndedAt.Sub(span.StartedAt).Milliseconds())
+		}
+		waterfall.Spans = append(waterfall.Spans, hop)
+	}
+	return waterfall
+}
+
+// Trace returns the waterfall of a request a client sent during the run.
+// The client can be left out if the run had only one.
+func (r *Run) Trace(origin string, requestID int) (Waterfall, error) {
+	if origin == "" {
+		clients := []string{}
+		for _, node := range r.Record().Design.Nodes {
+			if node.Type == ClientType {
+				clients = append(clients, node.ID)
+			}
+		}
+		if len(clients) != 1 {
+			return Waterfall{}, fmt.Errorf("%w %d, pick one of the clients %v", ErrAmbiguousRequest, requestID, clients)
+		}
+		origin = clients[0]
+	}
+
+	spans, err := r.tracer.Spans(r.tracer.TraceID(origin, requestID))
+	if err != nil {
+		return Waterfall{}, fmt.Errorf("%w: request %d of client %s", ErrTraceNotFound, requestID, origin)
+	}
+	return NewWaterfall(spans), nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrUnknownTraceFormat = errors.New("unknown trace format")
+
+const (
+	OTLPFormat   string = "otlp"
+	JaegerFormat string = "jaeger"
+)
+
+// TraceExportTimeout bounds how long sending a run's traces to a collector
+// can take.
+const TraceExportTimeout = 30 * time.Second
+
+// TraceScope names the instrumentation that produced the spans.
+const TraceScope = "systemdesigner"
+
+// OTLP-JSON, the JSON encoding of the OpenTelemetry trace export request,
+// with only the fields the simulation fills in.
+type OTLPTraces struct {
+	ResourceSpans []OTLPResourceSpans `json:"resourceSpans"`
+}
+
+type OTLPResourceSpans struct {
+	Resource   OTLPResource     `json:"resource"`
+	ScopeSpans []OTLPScopeSpans `json:"scopeSpans"`
+}
+
+type OTLPResource struct {
+	Attributes []OTLPAttribute `json:"attributes"`
+}
+
+type OTLPScopeSpans struct {
+	Scope OTLPScope  `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+type OTLPSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []OTLPAttribute `json:"attributes"`
+	Events            []OTLPEvent     `json:"events"`
+	Status            OTLPStatus      `json:"status"`
+}
+
+type OTLPEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type OTLPStatus struct {
+	Code int `json:"code"`
+}
+
+type OTLPAttribute struct {
+	Key   string    `json:"key"`
+	Value OTLPValue `json:"value"`
+}
+
+// OTLPValue holds one of its fields. Integers are strings, as in the
+// protobuf JSON mapping.
+type OTLPValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+const (
+	otlpSpanKindServer = 2
+	otlpSpanKindClient = 3
+	otlpStatusOk       = 1
+	otlpStatusError    = 2
+)
+
+// Jaeger JSON, as returned by the Jaeger query API and accepted by its UI.
+type JaegerTraces struct {
+	Data []JaegerTrace `json:"data"`
+}
+
+type JaegerTrace struct {
+	TraceID   string                   `json:"traceID"`
+	Spans     []JaegerSpan             `json:"spans"`
+	Processes map[string]JaegerProcess `json:"processes"`
+}
+
+type JaegerSpan struct {
+	TraceID       string            `json:"traceID"`
+	SpanID        string            `json:"spanID"`
+	OperationName string            `json:"operationName"`
+	References    []JaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"`
+	Duration      int64             `json:"duration"`
+	Tags          []JaegerTag       `json:"tags"`
+	Logs          []JaegerLog       `json:"logs"`
+	ProcessID     string            `json:"processID"`
+}
+
+type JaegerReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type JaegerTag struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type JaegerLog struct {
+	Timestamp int64       `json:"timestamp"`
+	Fields    []JaegerTag `json:"fields"`
+}
+
+type JaegerProcess struct {
+	ServiceName string      `json:"serviceName"`
+	Tags        []JaegerTag `json:"tags"`
+}
+
+// ExportTraces encodes the ended spans of the run in the given format. Each
+// span covers a hop from when the request reached the node, with an event
+// marking when it left the queue.
+func (r *Run) ExportTraces(format string) (interface{}, error) {
+	switch format {
+	case OTLPFormat:
+		return NewOTLPTraces(r.tracer.Ended()), nil
+	case JaegerFormat:
+		return NewJaegerTraces(r.tracer.Ended()), nil
+	}
+	return nil, fmt.Errorf("%w %q, expected %s or %s", ErrUnknownTraceFormat, format, OTLPFormat, JaegerFormat)
+}
+
+// NewOTLPTraces groups the spans by node, each node being a resource.
+func NewOTLPTraces(traces [][]Span) OTLPTraces {
+	export := OTLPTraces{ResourceSpans: []OTLPResourceSpans{}}
+	resources := map[string]int{}
+
+	for _, spans := range traces {
+		for _, span := range spans {
+			index, ok := resources[span.NodeID]
+			if !ok {
+				index = len(export.ResourceSpans)
+				resources[span.NodeID] = index
+				export.ResourceSpans = append(export.ResourceSpans, OTLPResourceSpans{
+					Resource: OTLPResource{Attributes: []OTLPAttribute{
+						otlpString("service.name", span.NodeType),
+						otlpString("service.instance.id", span.NodeID),
+					}},
+					ScopeSpans: []OTLPScopeSpans{{Scope: OTLPScope{Name: TraceScope}, Spans: []OTLPSpan{}}},
+				})
+			}
+
+			kind := otlpSpanKindServer
+			if span.ParentID == "" {
+				kind = otlpSpanKindClient
+			}
+			status := otlpStatusOk
+			if span.Failed {
+				status = otlpStatusError
+			}
+
+			scope := &export.ResourceSpans[index].ScopeSpans[0]
+			scope.Spans = append(scope.Spans, OTLPSpan{
+				TraceID:           span.TraceID,
+				SpanID:            span.SpanID,
+				ParentSpanID:      span.ParentID,
+				Name:              spanName(span),
+				Kind:              kind,
+				StartTimeUnixNano: strconv.FormatInt(span.EnqueuedAt.UnixNano(), 10),
+				EndTimeUnixNano:   strconv.FormatInt(span.EndedAt.UnixNano(), 10),
+				Attributes: []OTLPAttribute{
+					otlpString("node.id", span.NodeID),
+					otlpString("request.origin", span.Origin),
+					otlpInt("request.id", int64(span.RequestID)),
+					otlpInt("queue.wait_ms", span.StartedAt.Sub(span.EnqueuedAt).Milliseconds()),
+					otlpInt("processing_ms", span.Processing.Milliseconds()),
+				},
+				Events: []OTLPEvent{{
+					TimeUnixNano: strconv.FormatInt(span.StartedAt.UnixNano(), 10),
+					Name:         "dequeued",
+				}},
+				Status: OTLPStatus{Code: status},
+			})
+		}
+	}
+	return export
+}
+
+// NewJaegerTraces gives each node of a trace its own process.
+func NewJaegerTraces(traces [][]Span) JaegerTraces {
+	export := JaegerTraces{Data: []JaegerTrace{}}
+
+	for _, spans := range traces {
+		trace := JaegerTrace{
+			TraceID:   spans[0].TraceID,
+			Spans:     []JaegerSpan{},
+			Processes: map[string]JaegerProcess{},
+		}
+		processes := map[string]string{}
+
+		for _, span := range spans {
+			processID, ok := processes[span.NodeID]
+			if !ok {
+				processID = fmt.Sprintf("p%d", len(processes)+1)
+				processes[span.NodeID] = processID
+				trace.Processes[processID] = JaegerProcess{
+					ServiceName: span.NodeType,
+					Tags:        []JaegerTag{{Key: "node.id", Type: "string", Value: span.NodeID}},
+				}
+			}
+
+			references := []JaegerReference{}
+			if span.ParentID != "" {
+				references = append(references, JaegerReference{RefType: "CHILD_OF", TraceID: span.TraceID, SpanID: span.ParentID})
+			}
+			tags := []JaegerTag{
+				{Key: "request.origin", Type: "string", Value: span.Origin},
+				{Key: "request.id", Type: "int64", Value: span.RequestID},
+				{Key: "queue.wait_ms", Type: "int64", Value: span.StartedAt.Sub(span.EnqueuedAt).Milliseconds()},
+				{Key: "processing_ms", Type: "int64", Value: span.Processing.Milliseconds()},
+			}
+			if span.Failed {
+				tags = append(tags, JaegerTag{Key: "error", Type: "bool", Value: true})
+			}
+
+			trace.Spans = append(trace.Spans, JaegerSpan{
+				TraceID:       span.TraceID,
+				SpanID:        span.SpanID,
+				OperationName: spanName(span),
+				References:    references,
+				StartTime:     span.EnqueuedAt.UnixMicro(),
+				Duration:      span.EndedAt.Sub(span.EnqueuedAt).Microseconds(),
+				Tags:          tags,
+				Logs: []JaegerLog{{
+					Timestamp: span.StartedAt.UnixMicro(),
+					Fields:    []JaegerTag{{Key: "event", Type: "string", Value: "dequeued"}},
+				}},
+				ProcessID: processID,
+			})
+		}
+		export.Data = append(export.Data, trace)
+	}
+	return export
+}
+
+func spanName(span Span) string {
+	if span.ParentID == "" {
+		return span.NodeType + " request"
+	}
+	return span.NodeType + " handle"
+}
+
+func otlpString(key string, value string) OTLPAttribute {
+	return OTLPAttribute{Key: key, Value: OTLPValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) OTLPAttribute {
+	formatted := strconv.FormatInt(value, 10)
+	return OTLPAttribute{Key: key, Value: OTLPValue{IntValue: &formatted}}
+}
+
+// TraceExporter sends the traces of every run that ends to an OTLP/HTTP
+// collector, such as Jaeger's on port 4318.
+type TraceExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func NewTraceExporter(endpoint string) *TraceExporter {
+	return &TraceExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: TraceExportTimeout},
+	}
+}
+
+// TraceExporterFromEnv returns an exporter to the OTLP_TRACES_ENDPOINT, or
+// nil if it is not set.
+func TraceExporterFromEnv() *TraceExporter {
+	endpoint := os.Getenv("OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	return NewTraceExporter(endpoint)
+}
+
+// Export posts the run's traces as OTLP-JSON. It only logs failures.
+func (e *TraceExporter) Export(run *Run) {
+	traces := run.tracer.Ended()
+	if len(traces) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(NewOTLPTraces(traces))
+	if err != nil {
+		log.Printf("run %s traces could not be encoded: %v", run.ID, err)
+		return
+	}
+
+	response, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("run %s traces could not be exported: %v", run.ID, err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		log.Printf("run %s traces were rejected by %s: %s", run.ID, e.endpoint, response.Status)
+		return
+	}
+	log.Printf("run %s exported %d traces to %s", run.ID, len(traces), e.endpoint)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrInvalidDesign = errors.New("invalid design")
+
+const (
+	ErrorSeverity   string = "error"
+	WarningSeverity string = "warning"
+)
+
+// Diagnostic is a single problem found in a design, pointing at the node or
+// edge it is about when there is one.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	NodeID   string `json:"nodeId,omitempty"`
+	EdgeID   string `json:"edgeId,omitempty"`
+}
+
+// Validation is the result of checking a design. Errors stop it from
+// running, warnings do not.
+type Validation struct {
+	Valid    bool         `json:"valid"`
+	Errors   []Diagnostic `json:"errors"`
+	Warnings []Diagnostic `json:"warnings"`
+}
+
+func (v *Validation) add(severity string, code string, nodeID string, edgeID string, format string, args ...interface{}) {
+	diagnostic := Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		NodeID:   nodeID,
+		EdgeID:   edgeID,
+	}
+
+	if severity == ErrorSeverity {
+		v.Errors = append(v.Errors, diagnostic)
+	} else {
+		v.Warnings = append(v.Warnings, diagnostic)
+	}
+	v.Valid = len(v.Errors) == 0
+}
+
+// ValidationError is returned when a design with errors is started.
+type ValidationError struct {
+	Validation Validation
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInvalidDesign, e.Validation.Errors[0].Message)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidDesign
+}
+
+// Validate checks that the design can run.
+func (s *System) Validate() Validation {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.validate()
+}
+
+// validate checks the design. Callers must hold the mutex.
+func (s *System) validate() Validation {
+	validation := Validation{
+		Valid:    true,
+		Errors:   []Diagnostic{},
+		Warnings: []Diagnostic{},
+	}
+
+	nodeIDs := []string{}
+	for nodeID := range s.nodeStore {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	edgeIDs := []string{}
+	for edgeID := range s.edgeStore {
+		edgeIDs = append(edgeIDs, edgeID)
+	}
+	sort.Strings(edgeIDs)
+
+	// Only edges between existing, compatible nodes take part in the checks
+	// on the shape of the graph
+	inbound := map[string][]Edge{}
+	outbound := map[string][]Edge{}
+	for _, edgeID := range edgeIDs {
+		edge := s.edgeStore[edgeID]
+
+		source, sourceExists := s.nodeStore[edge.SourceID]
+		target, targetExists := s.nodeStore[edge.TargetID]
+		if !sourceExists || !targetExists {
+			missing := edge.SourceID
+			if sourceExists {
+				missing = edge.TargetID
+			}
+			validation.add(ErrorSeverity, "dangling-edge", "", edge.ID, "edge refers to node %s, which does not exist", missing)
+			continue
+		}
+		if _, ok := source.(Sender); !ok {
+			validation.add(ErrorSeverity, "invalid-source", edge.SourceID, edge.ID, "%s cannot send requests", source.GetType())
+			continue
+		}
+		if _, ok := target.(Receiver); !ok {
+			validation.add(ErrorSeverity, "edge-into-client", edge.TargetID, edge.ID, "%s cannot receive requests", target.GetType())
+			continue
+		}
+
+		inbound[edge.TargetID] = append(inbound[edge.TargetID], edge)
+		outbound[edge.SourceID] = append(outbound[edge.SourceID], edge)
+	}
+
+	clients := []string{}
+	for _, nodeID := range nodeIDs {
+		node := s.nodeStore[nodeID]
+
+		switch node.(type) {
+		case *Client:
+			clients = append(clients, nodeID)
+			if len(outbound[nodeID]) == 0 {
+				validation.add(ErrorSeverity, "client-without-target", nodeID, "", "%s does not send requests anywhere", node.GetType())
+			} else if len(outbound[nodeID]) > 1 {
+				validation.add(ErrorSeverity, "multiple-outbound", nodeID, "", "%s can only send to one node, but has %d outgoing edges", node.GetType(), len(outbound[nodeID]))
+			}
+		case *LoadBalancer:
+			if len(outbound[nodeID]) == 0 {
+				validation.add(ErrorSeverity, "no-targets", nodeID, "", "%s has no targets to forward requests to", node.GetType())
+			}
+			if len(inbound[nodeID]) > 1 {
+				validation.add(ErrorSeverity, "multiple-inbound", nodeID, "", "%s accepts a single incoming edge, but has %d", node.GetType(), len(inbound[nodeID]))
+			}
+		}
+	}
+	if len(clients) == 0 {
+		validation.add(ErrorSeverity, "no-clients", "", "", "design has no clients to send requests")
+	}
+
+	// Nodes no client can reach never see a request
+	reached := map[string]bool{}
+	queue := append([]string{}, clients...)
+	for len(queue) > 0 {
+		nodeID := queue[0]
+		queue = queue[1:]
+		if reached[nodeID] {
+			continue
+		}
+		reached[nodeID] = true
+		for _, edge := range outbound[nodeID] {
+			queue = append(queue, edge.TargetID)
+		}
+	}
+	for _, nodeID := range nodeIDs {
+		nodeType := s.nodeStore[nodeID].GetType()
+		if len(inbound[nodeID]) == 0 && len(outbound[nodeID]) == 0 {
+			validation.add(WarningSeverity, "disconnected", nodeID, "", "%s is not connected to any other node", nodeType)
+		} else if !reached[nodeID] {
+			validation.add(WarningSeverity, "unreachable", nodeID, "", "%s cannot be reached from any client", nodeType)
+		}
+	}
+
+	// A request that comes back to a node it has passed through waits on
+	// itself, so report every edge that closes a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := map[string]int{}
+	var visit func(nodeID string, depth map[string]int)
+	visit = func(nodeID string, depth map[string]int) {
+		states[nodeID] = visiting
+		for _, edge := range outbound[nodeID] {
+			switch states[edge.TargetID] {
+			case unvisited:
+				depth[edge.TargetID] = depth[nodeID] + 1
+				visit(edge.TargetID, depth)
+			case visiting:
+				length := depth[nodeID] - depth[edge.TargetID] + 1
+				validation.add(ErrorSeverity, "cycle", edge.TargetID, edge.ID, "edge closes a cycle through %d node(s)", length)
+			}
+		}
+		states[nodeID] = visited
+	}
+	for _, nodeID := range nodeIDs {
+		if states[nodeID] == unvisited {
+			visit(nodeID, map[string]int{nodeID: 0})
+		}
+	}
+
+	return validation
+}
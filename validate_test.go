@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newDesign builds a system from node types keyed by ID and edges given as
+// source and target IDs. Edges are added as they are, even those the API
+// would refuse, so that the validator sees them.
+func newDesign(t *testing.T, nodes map[string]string, edges [][2]string) *System {
+	t.Helper()
+
+	record := SystemRecord{ID: "design"}
+	for id, nodeType := range nodes {
+		record.Nodes = append(record.Nodes, NodeRecord{ID: id, Type: nodeType})
+	}
+	system, err := NewSystemFromRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, edge := range edges {
+		id := fmt.Sprintf("e%d", i)
+		system.edgeStore[id] = Edge{ID: id, SourceID: edge[0], TargetID: edge[1]}
+	}
+	return system
+}
+
+func codes(diagnostics []Diagnostic) []string {
+	found := []string{}
+	for _, diagnostic := range diagnostics {
+		found = append(found, diagnostic.Code)
+	}
+	sort.Strings(found)
+	return found
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    map[string]string
+		edges    [][2]string
+		errors   []string
+		warnings []string
+	}{
+		{"valid", map[string]string{"c": ClientType, "s": ServerType}, [][2]string{{"c", "s"}}, []string{}, []string{}},
+		{"empty", map[string]string{}, nil, []string{"no-clients"}, []string{}},
+		{"client without target", map[string]string{"c": ClientType}, nil, []string{"client-without-target"}, []string{"disconnected"}},
+		{"multiple outbound", map[string]string{"c": ClientType, "s": ServerType, "d": DatabaseType}, [][2]string{{"c", "s"}, {"c", "d"}}, []string{"multiple-outbound"}, []string{}},
+		{"no targets", map[string]string{"c": ClientType, "lb": LoadBalancerType}, [][2]string{{"c", "lb"}}, []string{"no-targets"}, []string{}},
+		{"multiple inbound", map[string]string{"c1": ClientType, "c2": ClientType, "lb": LoadBalancerType, "s": ServerType}, [][2]string{{"c1", "lb"}, {"c2", "lb"}, {"lb", "s"}}, []string{"multiple-inbound"}, []string{}},
+		{"dangling edge", map[string]string{"c": ClientType, "s": ServerType}, [][2]string{{"c", "s"}, {"s", "gone"}}, []string{"dangling-edge"}, []string{}},
+		{"edge into client", map[string]string{"c": ClientType, "s": ServerType}, [][2]string{{"c", "s"}, {"s", "c"}}, []string{"edge-into-client"}, []string{}},
+		{"cycle", map[string]string{"c": ClientType, "s1": ServerType, "s2": ServerType}, [][2]string{{"c", "s1"}, {"s1", "s2"}, {"s2", "s1"}}, []string{"cycle"}, []string{}},
+		{"disconnected", map[string]string{"c": ClientType, "s": ServerType, "d": DatabaseType}, [][2]string{{"c", "s"}}, []string{}, []string{"disconnected"}},
+		{"unreachable", map[string]string{"c": ClientType, "s": ServerType, "s2": ServerType, "d": DatabaseType}, [][2]string{{"c", "s"}, {"s2", "d"}}, []string{}, []string{"unreachable", "unreachable"}},
+	}
+	for _, test := range tests {
+		system := newDesign(t, test.nodes, test.edges)
+		validation := system.Validate()
+		system.Close()
+
+		if !reflect.DeepEqual(codes(validation.Errors), test.errors) {
+			t.Errorf("%s: got errors %v, expected %v", test.name, codes(validation.Errors), test.errors)
+		}
+		if !reflect.DeepEqual(codes(validation.Warnings), test.warnings) {
+			t.Errorf("%s: got warnings %v, expected %v", test.name, codes(validation.Warnings), test.warnings)
+		}
+		if validation.Valid != (len(test.errors) == 0) {
+			t.Errorf("%s: valid is %v with errors %v", test.name, validation.Valid, validation.Errors)
+		}
+	}
+}